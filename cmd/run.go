@@ -4,9 +4,14 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
+	"io"
 	"os"
 
+	"github.com/alwaifu/monkey/pkg/ast"
 	"github.com/alwaifu/monkey/pkg/interpreter"
+	"github.com/alwaifu/monkey/pkg/lexer"
+	"github.com/alwaifu/monkey/pkg/object"
 	"github.com/alwaifu/monkey/pkg/vm"
 
 	"github.com/spf13/cobra"
@@ -16,19 +21,26 @@ var runVersion *int = new(int)
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
-	Use:   "run",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Use:   "run [script] [args...]",
+	Short: "Run a monkey script, or start a REPL without script",
+	Long: `Run parses and executes the whole script file, arguments after the script
+are exposed to the program as the global array "args". A "#!" shebang line
+at the top of the script is ignored. Without script an interactive REPL is
+started on stdin. For example:
+
+  monkey run rules.mk user.json
+  monkey run --ver 1`,
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		if *runVersion == 1 {
-			interpreter.Start(os.Stdin, os.Stdout)
+		if len(args) == 0 {
+			if *runVersion == 1 {
+				interpreter.Start(os.Stdin, os.Stdout)
+			} else {
+				vm.Start(os.Stdin, os.Stdout)
+			}
+			return
 		}
-		vm.Start(os.Stdin, os.Stdout)
+		os.Exit(runScript(args[0], args[1:], *runVersion, os.Stderr))
 	},
 }
 
@@ -46,4 +58,58 @@ func init() {
 	// runCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	runCmd.Flags().IntVar(runVersion, "ver", 2, "run version, version 1 will interprete ast tree directly, version 2 will use virtual machine")
+	// flags after script name belong to the script
+	runCmd.Flags().SetInterspersed(false)
+}
+
+// runScript executes script file and returns the process exit code,
+// errors are written to stderr with source position
+func runScript(file string, args []string, version int, stderr io.Writer) int {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	p := ast.NewParser(lexer.NewFileLexer(file, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintln(stderr, p.Errors().String())
+		return 1
+	}
+	scriptArgs := &object.Array{Elements: make([]object.Object, 0, len(args))}
+	for _, arg := range args {
+		scriptArgs.Elements = append(scriptArgs.Elements, object.String(arg))
+	}
+
+	var result object.Object
+	if version == 1 {
+		env := object.NewEnviroment()
+		env.Set("args", scriptArgs)
+		result = interpreter.Eval(program, env)
+	} else {
+		builtins := object.StandardBuiltins()
+		symbolTable := vm.NewSymbolTable(nil)
+		symbolTable.DefineBuiltins(builtins)
+		globals := make([]object.Object, vm.GlobalSize)
+		for i := range globals {
+			globals[i] = object.NULL
+		}
+		globals[symbolTable.Define("args").Index] = scriptArgs
+		compiler := vm.NewCompiler(symbolTable, nil, builtins)
+		if err := compiler.Compile(program); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		machine := vm.NewVM(compiler, globals)
+		if err := machine.Run(); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		result = machine.Result()
+	}
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprintln(stderr, err.Error())
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		args   []string
+		code   int
+		stderr string
+	}{
+		{"ok", "let x = 1;\nx + 1", nil, 0, ""},
+		{"shebang", "#!/usr/bin/env monkey run\nlet x = 1;", nil, 0, ""},
+		{"args", `if (len(args) != 2 or args[1] != "b") { "x" - 1 }`, []string{"a", "b"}, 0, ""},
+		{"wrong args", `if (len(args) != 2 or args[1] != "b") { "x" - 1 }`, []string{"a"}, 1, "script.mk:1:45: type mismatch: STRING - INTEGER"},
+		{"runtime error", "let a = 1;\na + \"x\"", nil, 1, "script.mk:2:3: type mismatch: INTEGER + STRING"},
+		{"parse error", "let = 1;", nil, 1, "script.mk:1:5: expected next token to be IDENT, got ASSIGN instead"},
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "script.mk")
+	for _, tt := range tests {
+		if err := os.WriteFile(file, []byte(tt.script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, version := range []int{1, 2} {
+			var stderr bytes.Buffer
+			code := runScript(file, tt.args, version, &stderr)
+			if code != tt.code {
+				t.Errorf("%s (ver %d): wrong exit code. want=%d, got=%d (%s)", tt.name, version, tt.code, code, stderr.String())
+			}
+			got := strings.TrimSpace(stderr.String())
+			if tt.stderr == "" && got != "" || !strings.HasSuffix(got, tt.stderr) {
+				t.Errorf("%s (ver %d): wrong stderr. want=%q, got=%q", tt.name, version, tt.stderr, got)
+			}
+		}
+	}
+
+	// 虚拟机中没有执行到let语句的全局变量为null
+	if err := os.WriteFile(file, []byte("if (false) { let x = 1 };\nx ?? 1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	if code := runScript(file, nil, 2, &stderr); code != 0 {
+		t.Errorf("unassigned global: wrong exit code. want=0, got=%d (%s)", code, stderr.String())
+	}
+	stderr.Reset()
+	if code := runScript(filepath.Join(dir, "missing.mk"), nil, 2, &stderr); code != 1 || !strings.Contains(stderr.String(), "missing.mk") {
+		t.Errorf("missing script: wrong result. code=%d, stderr=%q", code, stderr.String())
+	}
+}
@@ -0,0 +1,108 @@
+// Package monkey 提供嵌入到go程序中使用的高层接口
+//
+//	program, err := monkey.Compile(`user_level > 3 and country == "CN"`, &monkey.Options{
+//		Vars: []string{"user_level", "country"},
+//	})
+//	result, err := program.Run(ctx, map[string]any{"user_level": 5, "country": "CN"})
+//
+// 源码只编译一次, Program可以被多个goroutine并发执行
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/alwaifu/monkey/pkg/ast"
+	"github.com/alwaifu/monkey/pkg/lexer"
+	"github.com/alwaifu/monkey/pkg/object"
+	"github.com/alwaifu/monkey/pkg/vm"
+)
+
+// Options 编译选项
+type Options struct {
+	File string   // 源码文件名, 用于错误信息中的位置
+	Vars []string // 输入变量名, 执行时由Run的vars参数传入, 未传入的变量值为null
+
+	Builtins *object.Builtins // 程序可用的内置函数, nil表示标准内置函数(object.StandardBuiltins)
+}
+
+// Program 编译后的程序, 只读, 可以并发执行
+type Program struct {
+	bytecode   *vm.Bytecode
+	vars       map[string]int // input variable name -> global index
+	numGlobals int
+
+	pool sync.Pool // *vm.VM, reused across runs to avoid allocating stack
+}
+
+// Compile 编译源码, 返回的错误为ast.ErrorList(语法错误)或编译错误
+func Compile(src string, opts *Options) (*Program, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	p := ast.NewParser(lexer.NewFileLexer(opts.File, src))
+	program := p.ParseProgram()
+	if err := p.Errors().Err(); err != nil {
+		return nil, err
+	}
+
+	builtins := opts.Builtins
+	if builtins == nil {
+		builtins = object.StandardBuiltins()
+	}
+	symbolTable := vm.NewSymbolTable(nil)
+	symbolTable.DefineBuiltins(builtins)
+	vars := make(map[string]int, len(opts.Vars))
+	for _, name := range opts.Vars {
+		if _, ok := vars[name]; ok {
+			return nil, fmt.Errorf("variable %s declared more than once", name)
+		}
+		vars[name] = symbolTable.Define(name).Index
+	}
+	compiler := vm.NewCompiler(symbolTable, nil, builtins)
+	if err := compiler.Compile(program); err != nil {
+		return nil, err
+	}
+	return &Program{
+		bytecode:   compiler.Bytecode(),
+		vars:       vars,
+		numGlobals: symbolTable.NumDefinitions(),
+	}, nil
+}
+
+// Run 执行程序, 返回最后一个顶层表达式语句或顶层return的值(转换为go原生值, 参见object.ToGoValue),
+// 程序中没有表达式语句时返回nil. vars中只能包含编译时声明过的变量, 运行时错误为*vm.RuntimeError,
+// ctx取消时程序中止执行, 返回的错误包装了ctx.Err()
+func (p *Program) Run(ctx context.Context, vars map[string]any) (any, error) {
+	globals := make([]object.Object, p.numGlobals)
+	for i := range globals {
+		globals[i] = object.NULL
+	}
+	for name, v := range vars {
+		idx, ok := p.vars[name]
+		if !ok {
+			return nil, fmt.Errorf("undeclared variable %s", name)
+		}
+		obj, err := object.FromGoValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %w", name, err)
+		}
+		globals[idx] = obj
+	}
+	machine, ok := p.pool.Get().(*vm.VM)
+	if ok {
+		machine.Reset(globals)
+	} else {
+		machine = vm.NewVMWithBytecode(p.bytecode, globals)
+	}
+	defer p.pool.Put(machine)
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	result := machine.Result()
+	if result == nil {
+		return nil, nil
+	}
+	return object.ToGoValue(result)
+}
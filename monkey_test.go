@@ -0,0 +1,353 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alwaifu/monkey/pkg/object"
+)
+
+func TestProgramRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		vars     map[string]any
+		expected any
+	}{
+		{`1 + 2`, nil, int64(3)},
+		{`a + b`, map[string]any{"a": 1, "b": int64(2)}, int64(3)},
+		{`name + "!"`, map[string]any{"name": "monkey"}, "monkey!"},
+		{`if (flag) { 1 } else { 2 }`, map[string]any{"flag": false}, int64(2)},
+		{`a`, map[string]any{}, nil},
+		{`[a, b]`, map[string]any{"a": 1, "b": "x"}, []interface{}{int64(1), "x"}},
+		{`{"x": a}`, map[string]any{"a": true}, map[string]interface{}{"x": true}},
+		{`{1: a}`, map[string]any{"a": true}, map[interface{}]interface{}{int64(1): true}},
+		{`let h = {1: a}; h[1.0] = b; h`, map[string]any{"a": true, "b": false}, map[interface{}]interface{}{int64(1): false}},
+		{`a * b`, map[string]any{"a": 3, "b": 0.5}, 1.5},
+		{`let c = fn(x) { x * 2 }; c(a)`, map[string]any{"a": 21}, int64(42)},
+		{`range(a, 0, -2)`, map[string]any{"a": 5}, []interface{}{int64(5), int64(3), int64(1)}},
+		{`return a * 2; 0`, map[string]any{"a": 2}, int64(4)},
+		{`let x = 1;`, nil, nil},
+		{`a ?? null`, map[string]any{}, nil},
+		{`a == null`, map[string]any{"a": 1}, false},
+		{`1; let x = 2;`, nil, int64(1)},
+		{`a + 1; let x = 2; for (i in [1, 2]) { x += i }`, map[string]any{"a": 1}, int64(2)},
+		{`let f = fn() { 3 }; f(); let y = f() + 1;`, nil, int64(3)},
+		{`if (flag) { return 1 }; let x = 2;`, map[string]any{"flag": true}, int64(1)},
+		{`if (flag) { return 1 }; let x = 2;`, map[string]any{"flag": false}, nil},
+		{`if (flag) { let x = 1 }; x`, map[string]any{"flag": false}, nil},
+		{`let f = fn() { if (flag) { let x = 1 }; x }; f()`, map[string]any{"flag": false}, nil},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.input, &Options{Vars: []string{"a", "b", "name", "flag"}})
+		if err != nil {
+			t.Fatalf("input %q: compile error: %s", tt.input, err)
+		}
+		result, err := program.Run(context.Background(), tt.vars)
+		if err != nil {
+			t.Fatalf("input %q: run error: %s", tt.input, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("input %q: wrong result. want=%#v, got=%#v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestProgramErrors(t *testing.T) {
+	if _, err := Compile("let a = ;", nil); err == nil || err.Error() != "1:9: no prefix parse function for ; found" {
+		t.Errorf("wrong parse error: %v", err)
+	}
+	if _, err := Compile("a + 1", &Options{File: "rule.mk"}); err == nil || err.Error() != "rule.mk:1:1: undefined variable a" {
+		t.Errorf("wrong compile error: %v", err)
+	}
+	program, err := Compile(`a + 1`, &Options{File: "rule.mk", Vars: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := program.Run(context.Background(), map[string]any{"a": "x"}); err == nil || err.Error() != "rule.mk:1:3: type mismatch: STRING + INTEGER" {
+		t.Errorf("wrong runtime error: %v", err)
+	}
+	if _, err := program.Run(context.Background(), map[string]any{"b": 1}); err == nil || err.Error() != "undeclared variable b" {
+		t.Errorf("wrong error for undeclared variable: %v", err)
+	}
+	if _, err := program.Run(context.Background(), map[string]any{"a": make(chan int)}); err == nil {
+		t.Errorf("expected error for unsupported variable type")
+	}
+}
+
+func TestProgramBuiltins(t *testing.T) {
+	builtins := object.StandardBuiltins()
+	builtins.Remove("print")
+	err := builtins.Register("domain", 1, "domain(email) returns the part after @", func(_ object.Context, args ...object.Object) object.Object {
+		email, _ := args[0].(object.String)
+		_, domain, _ := strings.Cut(string(email), "@")
+		return object.String(domain)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := Compile(`map(split(emails, ","), domain)`, &Options{Vars: []string{"emails"}, Builtins: builtins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 编译后修改注册表不影响已编译的程序
+	builtins.Remove("map")
+	result, err := program.Run(context.Background(), map[string]any{"emails": "a@x.com,b@y.org"})
+	if err != nil || !reflect.DeepEqual(result, []interface{}{"x.com", "y.org"}) {
+		t.Errorf("wrong result: %#v, %v", result, err)
+	}
+	if _, err := Compile(`print(1)`, &Options{Builtins: builtins}); err == nil || err.Error() != "1:1: undefined variable print" {
+		t.Errorf("wrong error for removed builtin: %v", err)
+	}
+	if _, err := Compile(`domain("a@b")`, nil); err == nil {
+		t.Errorf("custom builtin leaked into standard builtins")
+	}
+	if _, err := Compile(`print(1)`, nil); err != nil {
+		t.Errorf("builtin removed from standard builtins: %v", err)
+	}
+	program, err = Compile(`domain("a@b", 1)`, &Options{Builtins: builtins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := program.Run(context.Background(), nil); err == nil || err.Error() != "1:7: wrong number of arguments. got=2, want=1" {
+		t.Errorf("wrong arity error: %v", err)
+	}
+
+	full := object.NewBuiltins()
+	for i := 0; i < object.MaxBuiltins; i++ {
+		if err := full.Register(fmt.Sprintf("f%d", i), 0, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := full.Register("more", 0, "", nil); err == nil || err.Error() != "too many builtins, limit is 256" {
+		t.Errorf("wrong error for too many builtins: %v", err)
+	}
+	if err := full.Register("f0", 1, "", nil); err != nil {
+		t.Errorf("replacing builtin of full registry failed: %v", err)
+	}
+}
+
+type testAddress struct {
+	Country string `monkey:"country"`
+	City    string
+}
+type testBase struct {
+	ID int `monkey:"id"`
+}
+type testCustomer struct {
+	testBase
+	Name    string       `monkey:"name"`
+	Address *testAddress `monkey:"address"`
+	Tags    []string     `monkey:"tags"`
+	Secret  string       `monkey:"-"`
+	Created time.Time    `monkey:"created"`
+	note    string
+}
+
+func (c testCustomer) Greet(greeting string) string { return greeting + ", " + c.Name }
+func (a *testAddress) Line() string                 { return a.City + ", " + a.Country }
+
+func testQuot(a, b int) int { return a / b }
+
+func TestProgramHostValues(t *testing.T) {
+	customer := testCustomer{
+		testBase: testBase{ID: 7},
+		Name:     "alice",
+		Address:  &testAddress{Country: "CN", City: "Hangzhou"},
+		Tags:     []string{"vip", "new"},
+		Created:  time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+		note:     "internal",
+	}
+	vars := map[string]any{
+		"c":      customer,
+		"scores": map[string]int{"a": 1, "b": 2},
+		"meta":   map[string]any{"source": "web", "ids": []int64{1, 2}},
+		"nobody": (*testCustomer)(nil),
+		"upper":  strings.ToUpper,
+		"div": func(a, b int8) (int8, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"city": func(a testAddress) string { return a.City },
+		"sum": func(xs ...float64) (total float64) {
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"pairs": func(m map[string]int) int { return len(m) },
+		"quot":  testQuot,
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`c["name"] + "@" + c["address"]["country"]`, "alice@CN"},
+		{`c["id"]`, int64(7)},
+		{`c["address"]["City"]`, "Hangzhou"},
+		{`contains(c["tags"], "vip")`, true},
+		{`c["created"]["year"] * 100 + c["created"]["month"]`, int64(202403)},
+		{`c["created"]["weekday"]`, "Friday"},
+		{`scores["a"] + scores["b"]`, int64(3)},
+		{`meta["ids"][1]`, int64(2)},
+		{`nobody`, nil},
+		{`upper(c["name"])`, "ALICE"},
+		{`div(7, 2)`, int64(3)},
+		{`city(c["address"])`, "Hangzhou"},
+		{`sum(1, 2.5)`, 3.5},
+		{`sum()`, 0.0},
+		{`pairs({"x": 1})`, int64(1)},
+		{`c["address"]`, testAddress{Country: "CN", City: "Hangzhou"}},
+		{`c["created"]`, customer.Created},
+		{`str(c["address"])`, "testAddress{country: CN, City: Hangzhou}"},
+		{`c.name + "@" + c.address.country`, "alice@CN"},
+		{`c.Greet("hi")`, "hi, alice"},
+		{`c.address.Line()`, "Hangzhou, CN"},
+		{`c.created.Format("2006-01-02")`, "2024-03-01"},
+		{`c.created.year`, int64(2024)},
+		{`nobody?.name ?? "anonymous"`, "anonymous"},
+		{`nobody?.address.country ?? "unknown"`, "unknown"},
+		{`c?.nickname ?? c.name`, "alice"},
+		{`meta.source`, "web"},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.input, &Options{Vars: names})
+		if err != nil {
+			t.Fatalf("input %q: compile error: %s", tt.input, err)
+		}
+		result, err := program.Run(context.Background(), vars)
+		if err != nil {
+			t.Fatalf("input %q: run error: %s", tt.input, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("input %q: wrong result. want=%#v, got=%#v", tt.input, tt.expected, result)
+		}
+	}
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`c["Secret"]`, "1:2: unknown field Secret of testCustomer"},
+		{`c["note"]`, "1:2: unknown field note of testCustomer"},
+		{`c[0]`, "1:2: unusable as record field: INTEGER"},
+		{`c["name"] = "bob"`, "1:11: index assignment not supported: RECORD"},
+		{`div(1, 0)`, "1:4: division by zero"},
+		{`div(1, "a")`, "1:4: argument 2: cannot convert STRING to int8"},
+		{`div(1, 300)`, "1:4: argument 2: 300 overflows int8"},
+		{`div(1)`, "1:4: wrong number of arguments. got=1, want=2"},
+		{`c.nickname`, "1:2: unknown field nickname of testCustomer"},
+		{`nobody.name`, "1:7: member access not supported: NULL"},
+		{`c.name = "bob"`, "1:8: index assignment not supported: RECORD"},
+		{`quot(1, 0)`, "1:5: github.com/alwaifu/monkey.testQuot panicked: runtime error: integer divide by zero"},
+	}
+	for _, tt := range errorTests {
+		program, err := Compile(tt.input, &Options{Vars: names})
+		if err != nil {
+			t.Fatalf("input %q: compile error: %s", tt.input, err)
+		}
+		if _, err := program.Run(context.Background(), vars); err == nil || err.Error() != tt.expected {
+			t.Errorf("input %q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	program, err := Compile(`n`, &Options{Vars: []string{"n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := program.Run(context.Background(), map[string]any{"n": uint64(math.MaxUint64)}); err == nil || err.Error() != "variable n: 18446744073709551615 overflows INTEGER" {
+		t.Errorf("wrong error for uint overflow: %v", err)
+	}
+	if result, err := program.Run(context.Background(), map[string]any{"n": uint64(math.MaxInt64)}); err != nil || result != int64(math.MaxInt64) {
+		t.Errorf("wrong result for max uint: %v, %v", result, err)
+	}
+
+	// 字段只转换一次
+	obj, _ := object.FromGoValue(customer)
+	record := obj.(*object.Record)
+	first, _ := record.Get("tags")
+	second, _ := record.Get("tags")
+	if first != second {
+		t.Errorf("record field converted more than once")
+	}
+}
+
+func TestProgramRunCancel(t *testing.T) {
+	program, err := Compile(`while (true) {}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := program.Run(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestProgramRunCancelInCallback(t *testing.T) {
+	program, err := Compile(`map([1, 2], fn(x) { while (true) { x += 1 } })`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := program.Run(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestProgramRunConcurrently(t *testing.T) {
+	program, err := Compile(`let double = fn(x) { x * 2 }; double(n) + 1`, &Options{Vars: []string{"n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			result, err := program.Run(context.Background(), map[string]any{"n": n})
+			if err != nil {
+				errs <- err
+			} else if result != int64(n*2+1) {
+				errs <- fmt.Errorf("n=%d: got %v", n, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkProgramRun(b *testing.B) {
+	env := map[string]interface{}{"a": 1, "b": 0, "c": 1, "d": 0, "e": 1, "f": 0, "g": 1, "h": 0, "i": 1, "j": 0, "k": 1, "l": 0, "m": 1, "n": 0, "o": 1, "p": 0, "q": 1, "r": 0, "s": 1, "t": 0, "u": 1, "v": 0, "w": 1, "x": 0, "y": 1, "z": 0}
+	input := "a!=1 or b<0 or c==-1 or d!=1 or e!=1 or f!=1 or g!=1 or h!=1 or i!=1 or j!=1 or k!=1 or l!=1 or m!=1 or n!=1 or o!=1 or p!=1 or q!=1 or r!=1 or s!=1 or t!=1 or u!=1 or v!=1 or w!=1 or x!=1 or y!=1 or z!=1"
+	vars := make([]string, 0, len(env))
+	for k := range env {
+		vars = append(vars, k)
+	}
+	program, err := Compile(input, &Options{Vars: vars})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if r, err := program.Run(context.Background(), env); err != nil || r != true {
+			b.Fatal("test failed, got", r, err)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 type Node interface {
 	TokenLiteral() string // 输出节点字面量 仅用于调试测试
 	String() string       // to debug
+	Pos() lexer.Position  // 节点在源码中的位置, 用于错误提示
 }
 type Statement interface {
 	Node
@@ -37,6 +38,12 @@ func (p *Program) TokenLiteral() string {
 		return ""
 	}
 }
+func (p *Program) Pos() lexer.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return lexer.Position{}
+}
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -60,6 +67,7 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() lexer.Position  { return ls.Token.Pos }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -86,6 +94,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() lexer.Position  { return rs.Token.Pos }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -98,6 +107,80 @@ func (rs *ReturnStatement) String() string {
 
 // ---
 
+var (
+	_ Node      = (*WhileStatement)(nil)
+	_ Statement = (*WhileStatement)(nil)
+)
+
+type WhileStatement struct {
+	Token     lexer.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() lexer.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) String() string {
+	return "while (" + ws.Condition.String() + ") " + ws.Body.String()
+}
+
+// ---
+
+var (
+	_ Node      = (*ForStatement)(nil)
+	_ Statement = (*ForStatement)(nil)
+)
+
+// ForStatement for (Variable in Iterable) Body
+type ForStatement struct {
+	Token    lexer.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() lexer.Position  { return fs.Token.Pos }
+func (fs *ForStatement) String() string {
+	return "for (" + fs.Variable.String() + " in " + fs.Iterable.String() + ") " + fs.Body.String()
+}
+
+// ---
+
+var (
+	_ Node      = (*BreakStatement)(nil)
+	_ Statement = (*BreakStatement)(nil)
+)
+
+type BreakStatement struct {
+	Token lexer.Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() lexer.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal + ";" }
+
+// ---
+
+var (
+	_ Node      = (*ContinueStatement)(nil)
+	_ Statement = (*ContinueStatement)(nil)
+)
+
+type ContinueStatement struct {
+	Token lexer.Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() lexer.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal + ";" }
+
+// ---
+
 var (
 	_ Node      = (*ExpressionStatement)(nil)
 	_ Statement = (*ExpressionStatement)(nil)
@@ -110,6 +193,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() lexer.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -131,6 +215,7 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() lexer.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 // ---
@@ -147,10 +232,28 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() lexer.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 // ---
 
+var (
+	_ Node       = (*FloatLiteral)(nil)
+	_ Expression = (*FloatLiteral)(nil)
+)
+
+type FloatLiteral struct {
+	Token lexer.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() lexer.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
+// ---
+
 var (
 	_ Node       = (*BooleanLiteral)(nil)
 	_ Expression = (*BooleanLiteral)(nil)
@@ -163,10 +266,27 @@ type BooleanLiteral struct {
 
 func (b *BooleanLiteral) expressionNode()      {}
 func (b *BooleanLiteral) TokenLiteral() string { return b.Token.Literal }
+func (b *BooleanLiteral) Pos() lexer.Position  { return b.Token.Pos }
 func (b *BooleanLiteral) String() string       { return b.Token.Literal }
 
 // ---
 
+var (
+	_ Node       = (*NullLiteral)(nil)
+	_ Expression = (*NullLiteral)(nil)
+)
+
+type NullLiteral struct {
+	Token lexer.Token
+}
+
+func (n *NullLiteral) expressionNode()      {}
+func (n *NullLiteral) TokenLiteral() string { return n.Token.Literal }
+func (n *NullLiteral) Pos() lexer.Position  { return n.Token.Pos }
+func (n *NullLiteral) String() string       { return n.Token.Literal }
+
+// ---
+
 var (
 	_ Node       = (*StringLiteral)(nil)
 	_ Expression = (*StringLiteral)(nil)
@@ -179,10 +299,39 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() lexer.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 // ---
 
+var (
+	_ Node       = (*TemplateLiteral)(nil)
+	_ Expression = (*TemplateLiteral)(nil)
+)
+
+// TemplateLiteral 带插值的字符串"a${x}b", Parts中的字符串部分为*StringLiteral, 值为所有部分转换为字符串后拼接的结果
+type TemplateLiteral struct {
+	Token lexer.Token
+	Parts []Expression
+}
+
+func (tl *TemplateLiteral) expressionNode()      {}
+func (tl *TemplateLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TemplateLiteral) Pos() lexer.Position  { return tl.Token.Pos }
+func (tl *TemplateLiteral) String() string {
+	var out bytes.Buffer
+	for _, part := range tl.Parts {
+		if str, ok := part.(*StringLiteral); ok {
+			out.WriteString(str.Value)
+		} else {
+			out.WriteString("${" + part.String() + "}")
+		}
+	}
+	return out.String()
+}
+
+// ---
+
 var (
 	_ Node       = (*ArrayLiteral)(nil)
 	_ Expression = (*ArrayLiteral)(nil)
@@ -195,6 +344,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() lexer.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	var elements []string
@@ -209,6 +359,34 @@ func (al *ArrayLiteral) String() string {
 
 // ---
 
+var (
+	_ Node       = (*HashLiteral)(nil)
+	_ Expression = (*HashLiteral)(nil)
+)
+
+type HashLiteral struct {
+	Token  lexer.Token
+	Keys   []Expression
+	Values []Expression // 与Keys一一对应, 保持源码中的顺序
+}
+
+func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() lexer.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for i, key := range hl.Keys {
+		pairs = append(pairs, key.String()+": "+hl.Values[i].String())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// ---
+
 var (
 	_ Node       = (*IndexExpression)(nil)
 	_ Expression = (*IndexExpression)(nil)
@@ -222,6 +400,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() lexer.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -234,6 +413,67 @@ func (ie *IndexExpression) String() string {
 
 // ---
 
+var (
+	_ Node       = (*SliceExpression)(nil)
+	_ Expression = (*SliceExpression)(nil)
+)
+
+// SliceExpression 切片left[start:end:step], 省略的部分为nil
+type SliceExpression struct {
+	Token lexer.Token // [
+	Left  Expression
+	Start Expression
+	End   Expression
+	Step  Expression
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() lexer.Position  { return se.Token.Pos }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	if se.Step != nil {
+		out.WriteString(":")
+		out.WriteString(se.Step.String())
+	}
+	out.WriteString("])")
+	return out.String()
+}
+
+// ---
+
+var (
+	_ Node       = (*MemberExpression)(nil)
+	_ Expression = (*MemberExpression)(nil)
+)
+
+// MemberExpression 成员访问a.b, Optional为true时为a?.b
+type MemberExpression struct {
+	Token    lexer.Token // . or ?.
+	Object   Expression
+	Property *Identifier
+	Optional bool
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Pos() lexer.Position  { return me.Token.Pos }
+func (me *MemberExpression) String() string {
+	return "(" + me.Object.String() + me.Token.Literal + me.Property.String() + ")"
+}
+
+// ---
+
 var (
 	_ Node       = (*PrefixExpression)(nil)
 	_ Expression = (*PrefixExpression)(nil)
@@ -247,6 +487,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() lexer.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -272,6 +513,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() lexer.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -298,6 +540,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() lexer.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -325,6 +568,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() lexer.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -344,10 +588,12 @@ type FunctionLiteral struct {
 	Token      lexer.Token
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Name       string // 作为let语句的值时绑定的名称, 用于函数递归调用自身
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() lexer.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -377,6 +623,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() lexer.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -392,10 +639,73 @@ func (ce *CallExpression) String() string {
 
 // ---
 
+var (
+	_ Node       = (*AssignExpression)(nil)
+	_ Expression = (*AssignExpression)(nil)
+)
+
+// AssignExpression 赋值表达式, Target为*Identifier或*IndexExpression, 值为赋值后的值
+type AssignExpression struct {
+	Token    lexer.Token
+	Target   Expression
+	Operator string // = += -= *= /=
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() lexer.Position  { return ae.Token.Pos }
+func (ae *AssignExpression) String() string {
+	return "(" + ae.Target.String() + " " + ae.Operator + " " + ae.Value.String() + ")"
+}
+
+// ---
+
+var (
+	_ Node       = (*MatchExpression)(nil)
+	_ Expression = (*MatchExpression)(nil)
+)
+
+// MatchExpression 模式匹配表达式, 按顺序尝试每个分支, 值为第一个匹配分支的结果, 没有分支匹配时为null
+type MatchExpression struct {
+	Token lexer.Token
+	Value Expression
+	Arms  []*MatchArm
+}
+
+// MatchArm 模式匹配的一个分支: Pattern if Guard => Body
+//
+// Pattern可以是字面量(类型和值都相同时匹配), 标识符(匹配任意值并绑定到该变量, `_`不绑定),
+// 数组(长度相同且每个元素都匹配), 哈希(包含所有的键且对应的值都匹配, 键必须是字面量)
+type MatchArm struct {
+	Pattern Expression
+	Guard   Expression // 可以为nil
+	Body    Expression
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() lexer.Position  { return me.Token.Pos }
+func (me *MatchExpression) String() string {
+	arms := make([]string, 0, len(me.Arms))
+	for _, arm := range me.Arms {
+		s := arm.Pattern.String()
+		if arm.Guard != nil {
+			s += " if " + arm.Guard.String()
+		}
+		arms = append(arms, s+" => "+arm.Body.String())
+	}
+	return "match (" + me.Value.String() + ") { " + strings.Join(arms, ", ") + " }"
+}
+
+// ---
+
 // Parser 语法解析器
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors ErrorList
+	level  int // curToken之前尚未闭合的`{`数量, 用于错误恢复
+	loops  int // 当前所在函数中嵌套的循环层数, 用于检查break/continue
 
 	curToken  lexer.Token
 	peekToken lexer.Token
@@ -408,7 +718,7 @@ func NewParser(l *lexer.Lexer) *Parser {
 	first, second := l.NextToken(), l.NextToken() // read two tokens, so curToken and peekToken are both set
 	p := &Parser{
 		l:         l,
-		errors:    []string{},
+		errors:    ErrorList{},
 		curToken:  first,
 		peekToken: second,
 	}
@@ -416,15 +726,23 @@ func NewParser(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = map[lexer.TokenType]func() Expression{
 		lexer.IDENT:    p.parseIdentifier,
 		lexer.INT:      p.parseIntegerLiteral,
+		lexer.FLOAT:    p.parseFloatLiteral,
 		lexer.TRUE:     p.parseBooleanLiteral,
 		lexer.FALSE:    p.parseBooleanLiteral,
+		lexer.NULL:     p.parseNullLiteral,
 		lexer.STRING:   p.parseStringLiteral,
 		lexer.BANG:     p.parsePrefixExpression,
 		lexer.MINUS:    p.parsePrefixExpression,
+		lexer.TILDE:    p.parsePrefixExpression,
 		lexer.LPAREN:   p.parseGroupedExpression,
 		lexer.IF:       p.parseIfExpression,
 		lexer.FUNCTION: p.parseFunctionLiteral,
 		lexer.LBRACKET: p.parseArrayLiteral,
+		lexer.LBRACE:   p.parseHashLiteral,
+		lexer.MATCH:    p.parseMatchExpression,
+
+		lexer.TEMPLATE_HEAD: p.parseTemplateLiteral,
+		lexer.ILLEGAL:       p.parseIllegal,
 	}
 	p.infixParseFns = map[lexer.TokenType]func(Expression) Expression{
 		lexer.PLUS:     p.parseInfixExpression,
@@ -440,8 +758,25 @@ func NewParser(l *lexer.Lexer) *Parser {
 		lexer.AND:      p.parseInfixExpression,
 		lexer.OR:       p.parseInfixExpression,
 
-		lexer.LPAREN:   p.parseCallExpression,
-		lexer.LBRACKET: p.parseIndexExpression,
+		lexer.PERCENT:   p.parseInfixExpression,
+		lexer.POWER:     p.parseInfixExpression,
+		lexer.AMPERSAND: p.parseInfixExpression,
+		lexer.PIPE:      p.parseInfixExpression,
+		lexer.CARET:     p.parseInfixExpression,
+		lexer.SHL:       p.parseInfixExpression,
+		lexer.SHR:       p.parseInfixExpression,
+
+		lexer.LPAREN:       p.parseCallExpression,
+		lexer.LBRACKET:     p.parseIndexExpression,
+		lexer.DOT:          p.parseMemberExpression,
+		lexer.QUESTION_DOT: p.parseMemberExpression,
+		lexer.NULLISH:      p.parseInfixExpression,
+
+		lexer.ASSIGN:          p.parseAssignExpression,
+		lexer.PLUS_ASSIGN:     p.parseAssignExpression,
+		lexer.MINUS_ASSIGN:    p.parseAssignExpression,
+		lexer.ASTERISK_ASSIGN: p.parseAssignExpression,
+		lexer.SLASH_ASSIGN:    p.parseAssignExpression,
 	}
 	return p
 }
@@ -450,7 +785,7 @@ func (p *Parser) ParseProgram() *Program {
 	program := &Program{}
 	program.Statements = []Statement{}
 	for p.curToken.Type != lexer.EOF {
-		stmt := p.parseStatement()
+		stmt := p.parseStatementWithRecovery()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -458,12 +793,61 @@ func (p *Parser) ParseProgram() *Program {
 	}
 	return program
 }
+
+// bailout 解析出错时中止当前语句的解析
+type bailout struct{}
+
+// parseStatementWithRecovery 解析一条语句, 出错时丢弃该语句并跳过剩余的词法单元,
+// 使一次解析能报告所有互不相关的语法错误, 而不是由第一个错误引发的一连串错误
+func (p *Parser) parseStatementWithRecovery() (stmt Statement) {
+	level := p.level
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			p.synchronize(level)
+			stmt = nil
+		}
+	}()
+	return p.parseStatement()
+}
+
+// synchronize 跳过出错语句剩余的词法单元, 停在语句结尾的`;`或者下一条语句开始之前,
+// 出错语句中嵌套的`{}`会被整体跳过. level为出错语句开始时的p.level
+func (p *Parser) synchronize(level int) {
+	for ; p.curToken.Type != lexer.EOF; p.nextToken() {
+		if p.curToken.Type == lexer.RBRACE && p.level <= level && level > 0 {
+			return // 已经读到外层语句块的结尾, 交给parseBlockStatement处理
+		}
+		if p.level > level || p.curToken.Type == lexer.LBRACE {
+			continue
+		}
+		if p.curToken.Type == lexer.SEMICOLON {
+			return
+		}
+		switch p.peekToken.Type {
+		case lexer.LET, lexer.RETURN, lexer.WHILE, lexer.FOR, lexer.BREAK, lexer.CONTINUE, lexer.EOF:
+			return
+		case lexer.RBRACE:
+			if level > 0 {
+				return
+			}
+		}
+	}
+}
 func (p *Parser) parseStatement() Statement {
 	switch p.curToken.Type {
 	case lexer.LET:
 		return p.parseLetStatement()
 	case lexer.RETURN:
 		return p.parseReturnStatement()
+	case lexer.WHILE:
+		return p.parseWhileStatement()
+	case lexer.FOR:
+		return p.parseForStatement()
+	case lexer.BREAK, lexer.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		stmt := &ExpressionStatement{Token: p.curToken}
 		stmt.Expression = p.parseExpression(LOWEST)
@@ -484,6 +868,9 @@ func (p *Parser) parseLetStatement() *LetStatement {
 	}
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 	if p.peekToken.Type == (lexer.SEMICOLON) {
 		p.nextToken()
 	}
@@ -498,38 +885,197 @@ func (p *Parser) parseReturnStatement() *ReturnStatement {
 	}
 	return stmt
 }
+func (p *Parser) parseWhileStatement() *WhileStatement {
+	stmt := &WhileStatement{Token: p.curToken}
+	if !p.expectPeek(lexer.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(lexer.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(lexer.LBRACE) {
+		return nil
+	}
+	p.loops++
+	defer func() { p.loops-- }()
+	stmt.Body = p.parseBlockStatement()
+	if p.peekToken.Type == (lexer.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+func (p *Parser) parseForStatement() *ForStatement {
+	stmt := &ForStatement{Token: p.curToken}
+	if !p.expectPeek(lexer.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(lexer.IDENT) {
+		return nil
+	}
+	stmt.Variable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(lexer.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(lexer.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(lexer.LBRACE) {
+		return nil
+	}
+	p.loops++
+	defer func() { p.loops-- }()
+	stmt.Body = p.parseBlockStatement()
+	if p.peekToken.Type == (lexer.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseLoopControlStatement 解析break/continue, 只能出现在循环体中
+func (p *Parser) parseLoopControlStatement() Statement {
+	if p.loops == 0 {
+		p.addError(p.curToken, nil, "%s outside loop", p.curToken.Literal)
+	}
+	var stmt Statement
+	if p.curToken.Type == lexer.BREAK {
+		stmt = &BreakStatement{Token: p.curToken}
+	} else {
+		stmt = &ContinueStatement{Token: p.curToken}
+	}
+	if p.peekToken.Type == (lexer.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
 func (p *Parser) parseIdentifier() Expression {
 	return &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 func (p *Parser) parseIntegerLiteral() Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, nil, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 	return &IntegerLiteral{Token: p.curToken, Value: value}
 }
+func (p *Parser) parseFloatLiteral() Expression {
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(p.curToken, nil, "could not parse %q as float", p.curToken.Literal)
+		return nil
+	}
+	return &FloatLiteral{Token: p.curToken, Value: value}
+}
 func (p *Parser) parseBooleanLiteral() Expression {
 	return &BooleanLiteral{Token: p.curToken, Value: p.curToken.Type == lexer.TRUE}
 }
+func (p *Parser) parseNullLiteral() Expression {
+	return &NullLiteral{Token: p.curToken}
+}
 func (p *Parser) parseStringLiteral() Expression {
 	return &StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
+func (p *Parser) parseTemplateLiteral() Expression {
+	template := &TemplateLiteral{Token: p.curToken}
+	for {
+		if p.curToken.Literal != "" {
+			template.Parts = append(template.Parts, &StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if p.curToken.Type == lexer.TEMPLATE_TAIL {
+			return template
+		}
+		if p.peekToken.Type == lexer.TEMPLATE_MIDDLE || p.peekToken.Type == lexer.TEMPLATE_TAIL {
+			p.addError(p.peekToken, nil, "empty string interpolation")
+		}
+		p.nextToken()
+		template.Parts = append(template.Parts, p.parseExpression(LOWEST))
+		if p.peekToken.Type != lexer.TEMPLATE_MIDDLE && p.peekToken.Type != lexer.TEMPLATE_TAIL {
+			p.peekError([]lexer.TokenType{lexer.TEMPLATE_TAIL}, "expected } to close string interpolation, got %s instead", p.peekToken.Type)
+		}
+		p.nextToken()
+	}
+}
+
+// parseIllegal 报告词法分析的错误, 如未结束的字符串
+func (p *Parser) parseIllegal() Expression {
+	p.addError(p.curToken, nil, "%s", p.curToken.Err)
+	return nil
+}
 func (p *Parser) parseArrayLiteral() Expression {
 	array := &ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(lexer.RBRACKET)
 	return array
 }
+func (p *Parser) parseHashLiteral() Expression {
+	hash := &HashLiteral{Token: p.curToken}
+	for p.peekToken.Type != lexer.RBRACE {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+		if !p.expectPeek(lexer.COLON) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		hash.Keys = append(hash.Keys, key)
+		hash.Values = append(hash.Values, value)
+		if p.peekToken.Type != lexer.RBRACE && !p.expectPeek(lexer.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(lexer.RBRACE) {
+		return nil
+	}
+	return hash
+}
 func (p *Parser) parseIndexExpression(left Expression) Expression {
 	exp := &IndexExpression{Token: p.curToken, Left: left}
+	if p.peekToken.Type == lexer.COLON { // left[:end]
+		return p.parseSliceExpression(&SliceExpression{Token: exp.Token, Left: left})
+	}
 	p.nextToken()
 	exp.Index = p.parseExpression(LOWEST)
+	if p.peekToken.Type == lexer.COLON {
+		return p.parseSliceExpression(&SliceExpression{Token: exp.Token, Left: left, Start: exp.Index})
+	}
+	if !p.expectPeek(lexer.RBRACKET) {
+		return nil
+	}
+	return exp
+}
+
+// parseSliceExpression 从start之后的冒号开始解析[start:end:step]的剩余部分
+func (p *Parser) parseSliceExpression(exp *SliceExpression) Expression {
+	p.nextToken()
+	if p.peekToken.Type != lexer.COLON && p.peekToken.Type != lexer.RBRACKET {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+	}
+	if p.peekToken.Type == lexer.COLON {
+		p.nextToken()
+		if p.peekToken.Type != lexer.RBRACKET {
+			p.nextToken()
+			exp.Step = p.parseExpression(LOWEST)
+		}
+	}
 	if !p.expectPeek(lexer.RBRACKET) {
 		return nil
 	}
 	return exp
 }
+func (p *Parser) parseMemberExpression(object Expression) Expression {
+	exp := &MemberExpression{Token: p.curToken, Object: object, Optional: p.curToken.Type == lexer.QUESTION_DOT}
+	// 关键字也可以作为成员名, 例如h.match
+	if lexer.LookupIdent(p.peekToken.Literal) != p.peekToken.Type {
+		p.peekError([]lexer.TokenType{lexer.IDENT}, "expected next token to be %s, got %s instead", lexer.IDENT, p.peekToken.Type)
+	}
+	p.nextToken()
+	exp.Property = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
 func (p *Parser) parsePrefixExpression() Expression {
 	expression := &PrefixExpression{
 		Token:    p.curToken,
@@ -546,10 +1092,28 @@ func (p *Parser) parseInfixExpression(left Expression) Expression {
 		Left:     left,
 	}
 	curPrecedence := precedence(p.curToken)
+	if p.curToken.Type == lexer.POWER {
+		curPrecedence-- // 右结合: 2 ** 3 ** 2 == 2 ** (3 ** 2)
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(curPrecedence)
 	return expression
 }
+func (p *Parser) parseAssignExpression(target Expression) Expression {
+	switch target := target.(type) {
+	case *Identifier, *IndexExpression:
+	case *MemberExpression:
+		if target.Optional {
+			p.addError(p.curToken, nil, "invalid assignment target %s", target.String())
+		}
+	default:
+		p.addError(p.curToken, nil, "invalid assignment target %s", target.String())
+	}
+	expression := &AssignExpression{Token: p.curToken, Target: target, Operator: p.curToken.Literal}
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1) // 右结合: a = b = 1
+	return expression
+}
 func (p *Parser) parseGroupedExpression() Expression {
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
@@ -574,6 +1138,16 @@ func (p *Parser) parseIfExpression() Expression {
 	expression.Consequence = p.parseBlockStatement()
 	if p.peekToken.Type == lexer.ELSE {
 		p.nextToken()
+		if p.peekToken.Type == lexer.IF {
+			// else if (...) {...} 等价于 else { if (...) {...} }
+			p.nextToken()
+			tok := p.curToken
+			alternative := p.parseIfExpression()
+			expression.Alternative = &BlockStatement{Token: tok, Statements: []Statement{
+				&ExpressionStatement{Token: tok, Expression: alternative},
+			}}
+			return expression
+		}
 		if !p.expectPeek(lexer.LBRACE) {
 			return nil
 		}
@@ -581,14 +1155,112 @@ func (p *Parser) parseIfExpression() Expression {
 	}
 	return expression
 }
+func (p *Parser) parseMatchExpression() Expression {
+	expression := &MatchExpression{Token: p.curToken}
+	if !p.expectPeek(lexer.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	expression.Value = p.parseExpression(LOWEST)
+	if !p.expectPeek(lexer.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(lexer.LBRACE) {
+		return nil
+	}
+	for p.peekToken.Type != lexer.RBRACE {
+		p.nextToken()
+		arm := &MatchArm{Pattern: p.parsePattern(map[string]bool{})}
+		if p.peekToken.Type == lexer.IF {
+			p.nextToken()
+			p.nextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(lexer.ARROW) {
+			return nil
+		}
+		p.nextToken()
+		arm.Body = p.parseExpression(LOWEST)
+		expression.Arms = append(expression.Arms, arm)
+		if p.peekToken.Type != lexer.RBRACE && !p.expectPeek(lexer.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(lexer.RBRACE) {
+		return nil
+	}
+	return expression
+}
+
+// parsePattern 解析match分支的模式, names为模式中已经绑定的变量名, 同一模式中不能重复绑定
+func (p *Parser) parsePattern(names map[string]bool) Expression {
+	switch p.curToken.Type {
+	case lexer.IDENT:
+		if p.curToken.Literal != "_" {
+			if names[p.curToken.Literal] {
+				p.addError(p.curToken, nil, "duplicate binding %s in pattern", p.curToken.Literal)
+			}
+			names[p.curToken.Literal] = true
+		}
+		return p.parseIdentifier()
+	case lexer.LBRACKET:
+		array := &ArrayLiteral{Token: p.curToken, Elements: []Expression{}}
+		for p.peekToken.Type != lexer.RBRACKET {
+			p.nextToken()
+			array.Elements = append(array.Elements, p.parsePattern(names))
+			if p.peekToken.Type != lexer.RBRACKET && !p.expectPeek(lexer.COMMA) {
+				return nil
+			}
+		}
+		p.nextToken()
+		return array
+	case lexer.LBRACE:
+		hash := &HashLiteral{Token: p.curToken}
+		for p.peekToken.Type != lexer.RBRACE {
+			p.nextToken()
+			hash.Keys = append(hash.Keys, p.parseLiteralPattern())
+			if !p.expectPeek(lexer.COLON) {
+				return nil
+			}
+			p.nextToken()
+			hash.Values = append(hash.Values, p.parsePattern(names))
+			if p.peekToken.Type != lexer.RBRACE && !p.expectPeek(lexer.COMMA) {
+				return nil
+			}
+		}
+		p.nextToken()
+		return hash
+	default:
+		return p.parseLiteralPattern()
+	}
+}
+
+// parseLiteralPattern 解析字面量模式, 数字字面量可以带负号
+func (p *Parser) parseLiteralPattern() Expression {
+	switch p.curToken.Type {
+	case lexer.INT, lexer.FLOAT, lexer.STRING, lexer.TRUE, lexer.FALSE:
+		return p.prefixParseFns[p.curToken.Type]()
+	case lexer.MINUS:
+		if p.peekToken.Type == lexer.INT || p.peekToken.Type == lexer.FLOAT {
+			expression := &PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+			p.nextToken()
+			expression.Right = p.prefixParseFns[p.curToken.Type]()
+			return expression
+		}
+	}
+	p.addError(p.curToken, nil, "invalid pattern %s", p.curToken.Literal)
+	return nil
+}
 func (p *Parser) parseBlockStatement() *BlockStatement {
 	block := &BlockStatement{Token: p.curToken}
 	block.Statements = []Statement{}
 	p.nextToken()
 	for p.curToken.Type != lexer.RBRACE && p.curToken.Type != lexer.EOF {
-		stmt := p.parseStatement()
+		stmt := p.parseStatementWithRecovery()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
+		} else if p.curToken.Type == lexer.RBRACE {
+			break // 出错语句已经读到了语句块的结尾
 		}
 		p.nextToken()
 	}
@@ -603,6 +1275,10 @@ func (p *Parser) parseFunctionLiteral() Expression {
 	if !p.expectPeek(lexer.LBRACE) {
 		return nil
 	}
+	// break/continue不能跨越函数
+	outerLoops := p.loops
+	p.loops = 0
+	defer func() { p.loops = outerLoops }()
 	lit.Body = p.parseBlockStatement()
 	return lit
 }
@@ -652,7 +1328,7 @@ func (p *Parser) parseExpressionList(end lexer.TokenType) []Expression {
 func (p *Parser) parseExpression(curPrecedence int) Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.errors = append(p.errors, fmt.Sprintf("no prefix parse function for %s found", p.curToken.Type))
+		p.addError(p.curToken, nil, "no prefix parse function for %s found", p.curToken.Type)
 		return nil
 	}
 	leftExp := prefix()
@@ -667,6 +1343,14 @@ func (p *Parser) parseExpression(curPrecedence int) Expression {
 	return leftExp
 }
 func (p *Parser) nextToken() {
+	switch p.curToken.Type {
+	case lexer.LBRACE:
+		p.level++
+	case lexer.RBRACE:
+		if p.level > 0 {
+			p.level--
+		}
+	}
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
 }
@@ -677,10 +1361,29 @@ func (p *Parser) expectPeek(t lexer.TokenType) bool {
 		p.nextToken()
 		return true
 	} else {
-		p.errors = append(p.errors, fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type))
+		p.peekError([]lexer.TokenType{t}, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
 		return false
 	}
 }
-func (p *Parser) Errors() []string {
+
+// peekError 报告peekToken不符合预期的错误, peekToken本身是词法错误时报告词法错误
+func (p *Parser) peekError(expected []lexer.TokenType, format string, a ...interface{}) {
+	if p.peekToken.Type == lexer.ILLEGAL {
+		p.addError(p.peekToken, expected, "%s", p.peekToken.Err)
+	}
+	p.addError(p.peekToken, expected, format, a...)
+}
+func (p *Parser) Errors() ErrorList {
 	return p.errors
 }
+
+// addError 记录语法错误并中止当前语句的解析, found为出错位置的词法单元, expected为期望的词法单元类型
+func (p *Parser) addError(found lexer.Token, expected []lexer.TokenType, format string, a ...interface{}) {
+	p.errors = append(p.errors, &ParseError{
+		Pos:      found.Pos,
+		Expected: expected,
+		Found:    found,
+		Msg:      fmt.Sprintf(format, a...),
+	})
+	panic(bailout{})
+}
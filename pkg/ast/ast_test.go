@@ -2,7 +2,6 @@ package ast
 
 import (
 	"fmt"
-	"strings"
 	"testing"
 
 	"github.com/alwaifu/monkey/pkg/lexer"
@@ -467,6 +466,15 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{"user_id % 100 < 10", "((user_id % 100) < 10)"},
+		{"a * b % c", "((a * b) % c)"},
+		{"-2 ** 2", "(-(2 ** 2))"},
+		{"2 ** 3 ** 2", "(2 ** (3 ** 2))"},
+		{"a * b ** c", "(a * (b ** c))"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)))"},
+		{"a & b << 1 + c", "(a & (b << (1 + c)))"},
+		{"a >> 1 == b | c", "((a >> 1) == (b | c))"},
+		{"~a & b", "((~a) & b)"},
 
 		{
 			"a * [1, 2, 3, 4][b * c] * d",
@@ -489,9 +497,169 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		}
 	}
 }
+func TestElseIfExpression(t *testing.T) {
+	input := "if (x < 0) { -1 } else if (x == 0) { 0 } else { 1 }"
+	p := NewParser(lexer.NewLexer(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has errors: %s", p.Errors())
+	}
+	exp, ok := program.Statements[0].(*ExpressionStatement).Expression.(*IfExpression)
+	if !ok {
+		t.Fatalf("not *IfExpression. got=%T", program.Statements[0].(*ExpressionStatement).Expression)
+	}
+	inner, ok := exp.Alternative.Statements[0].(*ExpressionStatement).Expression.(*IfExpression)
+	if !ok {
+		t.Fatalf("alternative is not else if. got=%s", exp.Alternative)
+	}
+	if inner.Condition.String() != "(x == 0)" || inner.Alternative.String() != "1" {
+		t.Errorf("wrong else if branch. got=%s", inner)
+	}
+}
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { 1 => a, -2.5 => b, _ => c }", "match (x) { 1 => a, (-2.5) => b, _ => c }"},
+		{`match (x) { "a" => 1, true => 2, }`, `match (x) { a => 1, true => 2 }`},
+		{"match (p) { [a, [b, _]] if a > b => a + b }", "match (p) { [a, [b, _]] if (a > b) => (a + b) }"},
+		{`match (h) { {"k": v, 1: [_, _]} => v }`, `match (h) { {k: v, 1: [_, _]} => v }`},
+		{"match (x) { [] => 0, {} => 1 }", "match (x) { [] => 0, {} => 1 }"},
+		{"match (x) {}", "match (x) {  }"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.Errors() {
+			t.Errorf("input %q: %s", tt.input, e)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { a + 1 => 1 }", "1:15: expected next token to be =>, got PLUS instead"},
+		{"match (x) { [a, a] => 1 }", "1:17: duplicate binding a in pattern"},
+		{"match (x) { {k: 1} => 1 }", "1:14: invalid pattern k"},
+		{"match (x) { -a => 1 }", "1:13: invalid pattern -"},
+		{"match (x) { 1 => 1 2 => 2 }", "1:20: expected next token to be ,, got INT instead"},
+	}
+	for _, tt := range errorTests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0].Error() != tt.expected {
+			t.Errorf("input %q: wrong error. want=%q, got=%v", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1", "(x = 1)"},
+		{"x = y = 1 + 2", "(x = (y = (1 + 2)))"},
+		{"x += 1 * 2", "(x += (1 * 2))"},
+		{"a[0] -= 1", "((a[0]) -= 1)"},
+		{"h[\"k\"][1] *= x or y", "(((h[k])[1]) *= (x or y))"},
+		{"x /= 2; x", "(x /= 2)x"},
+		{"a.b.c += 1", "(((a.b).c) += 1)"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.Errors() {
+			t.Errorf("input %q: %s", tt.input, e)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	p := NewParser(lexer.NewLexer("1 + x = 2; f() += 1; a?.b = 1"))
+	p.ParseProgram()
+	expected := []string{"1:7: invalid assignment target (1 + x)", "1:16: invalid assignment target f()", "1:27: invalid assignment target (a?.b)"}
+	errs := p.Errors()
+	if len(errs) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%d (%s)", len(expected), len(errs), errs)
+	}
+	for i, msg := range expected {
+		if errs[i].Error() != msg {
+			t.Errorf("wrong error %d. want=%q, got=%q", i, msg, errs[i])
+		}
+	}
+}
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x }", "while ((x < 10)) x"},
+		{"for (item in [1, 2]) { item; }", "for (item in [1, 2]) item"},
+		{"while (true) { break; continue }", "while (true) break;continue;"},
+		{"for (x in xs) { if (x) { break } }", "for (x in xs) ifx break;"},
+		{"while (a) { let f = fn() { 1 }; for (b in c) { continue; } }", "while (a) let f = fn() 1;for (b in c) continue;"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.Errors() {
+			t.Errorf("input %q: %s", tt.input, e)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	stmt := NewParser(lexer.NewLexer("for (x in xs) { x }")).ParseProgram().Statements[0]
+	forStmt, ok := stmt.(*ForStatement)
+	if !ok {
+		t.Fatalf("stmt is not *ForStatement. got=%T", stmt)
+	}
+	testIdentifier(t, forStmt.Variable, "x")
+	testIdentifier(t, forStmt.Iterable, "xs")
+	if len(forStmt.Body.Statements) != 1 {
+		t.Errorf("body has wrong number of statements. got=%d", len(forStmt.Body.Statements))
+	}
+}
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"if (true) { continue }", "1:13: continue outside loop"},
+		{"while (true) { fn() { break } }", "1:23: break outside loop"},
+		{"for (x in xs) {}; continue", "1:19: continue outside loop"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		p.ParseProgram()
+		errs := p.Errors()
+		if len(errs) != 1 || errs[0].Error() != tt.expected {
+			t.Errorf("input %q: wrong errors. want=%q, got=%v", tt.input, tt.expected, errs)
+		}
+	}
+}
 func TestStringLiteralExpression(t *testing.T) {
-	tests := []string{`"hello world"`, `"hello\nworld"`}
-	for _, input := range tests {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"hello world"`, "hello world"},
+		{`"hello\nworld"`, "hello\nworld"},
+		{`"tab\t quote\" backslash\\ dollar\$"`, "tab\t quote\" backslash\\ dollar$"},
+		{`"\u{48}\u{e9}\u{1F600}"`, "Hé😀"},
+		{`"$x {y} $"`, "$x {y} $"},
+		{"`raw \\n ${x}\nline`", "raw \\n ${x}\nline"},
+	}
+	for _, tt := range tests {
+		input := tt.input
 		p := NewParser(lexer.NewLexer(input))
 		program := p.ParseProgram()
 		for _, e := range p.errors {
@@ -502,12 +670,89 @@ func TestStringLiteralExpression(t *testing.T) {
 		if !ok {
 			t.Fatalf("exp not *StringLiteral. got=%T", stmt.Expression)
 		}
-		expected := strings.Trim(input, "\"")
-		if literal.Value != expected {
+		if expected := tt.expected; literal.Value != expected {
 			t.Errorf("literal.Value not %q. got=%q", expected, literal.Value)
 		}
 	}
 }
+func TestTemplateLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		parts    int
+	}{
+		{`"Hello ${name}!"`, "Hello ${name}!", 3},
+		{`"${a}${b}"`, "${a}${b}", 2},
+		{`"sum: ${a + b * 2}"`, "sum: ${(a + (b * 2))}", 2},
+		{`"${ {"k": 1}["k"] } and ${"in${x}ner"}"`, "${({k: 1}[k])} and ${in${x}ner}", 3},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.errors {
+			t.Errorf("input %s: %s", tt.input, e)
+		}
+		template, ok := program.Statements[0].(*ExpressionStatement).Expression.(*TemplateLiteral)
+		if !ok {
+			t.Fatalf("input %s: exp not *TemplateLiteral. got=%T", tt.input, program.Statements[0].(*ExpressionStatement).Expression)
+		}
+		if template.String() != tt.expected || len(template.Parts) != tt.parts {
+			t.Errorf("input %s: wrong template. want=%q(%d parts), got=%q(%d parts)", tt.input, tt.expected, tt.parts, template.String(), len(template.Parts))
+		}
+	}
+}
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let s = "abc`, "1:9: unterminated string"},
+		{"let s = `abc", "1:9: unterminated raw string"},
+		{`"a\qb"; 1`, `1:1: invalid escape sequence \q`},
+		{`"\u{110000}"`, "1:1: invalid unicode escape sequence"},
+		{`"\u41"`, "1:1: invalid unicode escape sequence"},
+		{`"a ${} b"`, "1:6: empty string interpolation"},
+		{`"a ${x y} b"`, "1:8: expected } to close string interpolation, got IDENT instead"},
+		{`"a ${x} b`, "1:7: unterminated string"},
+		{`let s = (1 /* x`, "1:12: unterminated comment"},
+		{"1 @ 2", "1:3: illegal character '@'"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0].Error() != tt.expected {
+			t.Errorf("input %s: wrong error. want=%q, got=%v", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.errors {
+			t.Error(e)
+		}
+		stmt := program.Statements[0].(*ExpressionStatement)
+		literal, ok := stmt.Expression.(*FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+		if literal.String() != tt.input {
+			t.Errorf("literal.String() not %q. got=%q", tt.input, literal.String())
+		}
+	}
+}
 func TestParsingIndexExpressions(t *testing.T) {
 	input := "myArray[1 + 1]"
 	l := lexer.NewLexer(input)
@@ -529,3 +774,241 @@ func TestParsingIndexExpressions(t *testing.T) {
 		return
 	}
 }
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:3]", "(a[1:3])"},
+		{"a[:5]", "(a[:5])"},
+		{"a[1:]", "(a[1:])"},
+		{"a[:]", "(a[:])"},
+		{"a[::2]", "(a[::2])"},
+		{"a[1::-1]", "(a[1::(-1)])"},
+		{"a[i + 1:len(a) - 1:2]", "(a[(i + 1):(len(a) - 1):2])"},
+		{"a[1:][0]", "((a[1:])[0])"},
+		{"{a[1:]: 1}", "{(a[1:]): 1}"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.Errors() {
+			t.Errorf("input %q: %s", tt.input, e)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	for _, input := range []string{"a[1:2:3:4]", "a[1:2", "a[:] = 1"} {
+		p := NewParser(lexer.NewLexer(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for input %q", input)
+		}
+	}
+}
+func TestMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a.b", "(a.b)"},
+		{"a.b.c", "((a.b).c)"},
+		{"a?.b.c", "((a?.b).c)"},
+		{"a.b(1, 2)", "(a.b)(1, 2)"},
+		{"a.b[0].c", "(((a.b)[0]).c)"},
+		{"-a.b", "(-(a.b))"},
+		{"f(x).y", "(f(x).y)"},
+		{"h.match", "(h.match)"},
+		{"a ?? b ?? c", "((a ?? b) ?? c)"},
+		{"x ?? 0 > 5", "((x ?? 0) > 5)"},
+		{"a?.b ?? 1 + 2", "((a?.b) ?? (1 + 2))"},
+		{"x ?? 1 | 2", "(x ?? (1 | 2))"},
+		{"a.b ?? null", "((a.b) ?? null)"},
+		{"x == null", "(x == null)"},
+		{"h.null", "(h.null)"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.Errors() {
+			t.Errorf("input %q: %s", tt.input, e)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	p := NewParser(lexer.NewLexer("a.1"))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) == 0 || errs[0].Error() != "1:3: expected next token to be IDENT, got INT instead" {
+		t.Errorf("wrong errors for a.1: %s", errs)
+	}
+	p = NewParser(lexer.NewLexer("let null = 1"))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) == 0 || errs[0].Error() != "1:5: expected next token to be IDENT, got NULL instead" {
+		t.Errorf("wrong errors for let null: %s", errs)
+	}
+}
+func TestParsingHashLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{}`, `{}`},
+		{`{"one": 1, "two": 2, "three": 3}`, `{one: 1, two: 2, three: 3}`},
+		{`{1: true, true: "yes"}`, `{1: true, true: yes}`},
+		{`{"one": 0 + 1, "two": 10 - 8, "three": 15 / 5}`, `{one: (0 + 1), two: (10 - 8), three: (15 / 5)}`},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		for _, e := range p.errors {
+			t.Error(e)
+		}
+		stmt := program.Statements[0].(*ExpressionStatement)
+		hash, ok := stmt.Expression.(*HashLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+		}
+		if hash.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, hash.String())
+		}
+	}
+}
+func TestParsingHashLiteralErrors(t *testing.T) {
+	tests := []string{`{"one" 1}`, `{"one": 1 "two": 2}`, `{"one": 1`}
+	for _, input := range tests {
+		p := NewParser(lexer.NewLexer(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for input %q", input)
+		}
+	}
+}
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "rules.mk:1:7: expected next token to be ASSIGN, got INT instead"},
+		{"let x = 5;\nlet = 10;", "rules.mk:2:5: expected next token to be IDENT, got ASSIGN instead"},
+		{"1 +\n  ;", "rules.mk:2:3: no prefix parse function for ; found"},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewFileLexer("rules.mk", tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Fatalf("expected parser errors for input %q", tt.input)
+		}
+		if p.Errors()[0].Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, p.Errors()[0])
+		}
+	}
+}
+func TestNodePositions(t *testing.T) {
+	input := "let add = fn(a, b) {\n  a + b\n};\nadd(1, 2)"
+	program := NewParser(lexer.NewLexer(input)).ParseProgram()
+	fn := program.Statements[0].(*LetStatement).Value.(*FunctionLiteral)
+	body := fn.Body.Statements[0].(*ExpressionStatement).Expression
+	call := program.Statements[1].(*ExpressionStatement).Expression.(*CallExpression)
+	tests := []struct {
+		node     Node
+		expected string
+	}{
+		{program, "1:1"},
+		{fn, "1:11"},
+		{fn.Parameters[1], "1:17"},
+		{body, "2:5"},
+		{call, "4:4"},
+		{call.Arguments[1], "4:8"},
+	}
+	for _, tt := range tests {
+		if tt.node.Pos().String() != tt.expected {
+			t.Errorf("wrong position of %q. expected=%q, got=%q", tt.node, tt.expected, tt.node.Pos())
+		}
+	}
+}
+func TestParseErrorDetails(t *testing.T) {
+	p := NewParser(lexer.NewLexer("let x 5;"))
+	p.ParseProgram()
+	errs := p.Errors()
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error. got=%d (%s)", len(errs), errs)
+	}
+	err := errs[0]
+	if len(err.Expected) != 1 || err.Expected[0] != lexer.ASSIGN {
+		t.Errorf("err.Expected wrong. got=%v", err.Expected)
+	}
+	if err.Found.Type != lexer.INT || err.Found.Literal != "5" {
+		t.Errorf("err.Found wrong. got=%+v", err.Found)
+	}
+	if err.Msg != "expected next token to be ASSIGN, got INT instead" {
+		t.Errorf("err.Msg wrong. got=%q", err.Msg)
+	}
+	var e error = errs.Err()
+	if e == nil || e.Error() != "1:7: expected next token to be ASSIGN, got INT instead" {
+		t.Errorf("ErrorList.Err wrong. got=%v", e)
+	}
+	if (ErrorList{}).Err() != nil {
+		t.Errorf("empty ErrorList.Err should be nil")
+	}
+}
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+		expectedStmts  string
+	}{
+		{
+			"let x 5; let y = 10; y",
+			[]string{"1:7: expected next token to be ASSIGN, got INT instead"},
+			"let y = 10;y",
+		},
+		{
+			"let = 1;\nlet y = ;\nlet z = 3;\nz * )",
+			[]string{
+				"1:5: expected next token to be IDENT, got ASSIGN instead",
+				"2:9: no prefix parse function for ; found",
+				"4:5: no prefix parse function for ) found",
+			},
+			"let z = 3;",
+		},
+		{
+			"let f = fn(x) {\n  let y = ;\n  x +\n};\nf(1)",
+			[]string{
+				"2:11: no prefix parse function for ; found",
+				"4:1: no prefix parse function for } found",
+			},
+			"let f = fn(x) ;f(1)",
+		},
+		{
+			"let a = if (1 +) { let b = 2; b };\nlet c = 3;",
+			[]string{"1:16: no prefix parse function for ) found"},
+			"let c = 3;",
+		},
+		{
+			`{"a" 1}; return 2;`,
+			[]string{`1:6: expected next token to be :, got INT instead`},
+			"return 2;",
+		},
+	}
+	for _, tt := range tests {
+		p := NewParser(lexer.NewLexer(tt.input))
+		program := p.ParseProgram()
+		errs := p.Errors()
+		if len(errs) != len(tt.expectedErrors) {
+			t.Errorf("input %q: wrong number of errors. want=%d, got=%d\n%s", tt.input, len(tt.expectedErrors), len(errs), errs)
+			continue
+		}
+		for i, msg := range tt.expectedErrors {
+			if errs[i].Error() != msg {
+				t.Errorf("input %q: wrong error %d. want=%q, got=%q", tt.input, i, msg, errs[i])
+			}
+		}
+		if program.String() != tt.expectedStmts {
+			t.Errorf("input %q: wrong statements. want=%q, got=%q", tt.input, tt.expectedStmts, program.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alwaifu/monkey/pkg/lexer"
+)
+
+// ParseError 语法错误
+type ParseError struct {
+	Pos      lexer.Position
+	Expected []lexer.TokenType // 期望的词法单元类型, 为空表示期望一个表达式
+	Found    lexer.Token       // 实际遇到的词法单元
+	Msg      string
+}
+
+func (e *ParseError) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// ErrorList 一次解析中发现的全部语法错误, 按出现顺序排列
+type ErrorList []*ParseError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+	}
+}
+
+// Err 没有错误时返回nil, 否则返回错误列表本身
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// String 每行输出一个错误
+func (l ErrorList) String() string {
+	msgs := make([]string, 0, len(l))
+	for _, e := range l {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -5,19 +5,28 @@ import "github.com/alwaifu/monkey/pkg/lexer"
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or += or -= or *= or /=
 	OR          // ||
 	AND         // &&
 	EQUALS      // == or !=
 	LESSGREATER // > or >= or < or <=
+	NULLISH     // ?? (x ?? 0 > 5 == (x ?? 0) > 5)
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
+	SHIFT       // << or >>
 	SUM         // +
-	PRODUCT     // *
-	PREFIX      // -X or !X
+	PRODUCT     // * or / or %
+	PREFIX      // -X or !X or ~X
+	POWER       // ** (右结合, -2 ** 2 == -(2 ** 2))
 	CALL        // myFunction(X)
-	INDEX       // array[index]
+	INDEX       // array[index] or a.b or a?.b
 )
 
 func precedence(token lexer.Token) int {
 	switch token.Type {
+	case lexer.ASSIGN, lexer.PLUS_ASSIGN, lexer.MINUS_ASSIGN, lexer.ASTERISK_ASSIGN, lexer.SLASH_ASSIGN:
+		return ASSIGN
 	case lexer.OR:
 		return OR
 	case lexer.AND:
@@ -26,13 +35,25 @@ func precedence(token lexer.Token) int {
 		return EQUALS
 	case lexer.LT, lexer.LE, lexer.GT, lexer.GE:
 		return LESSGREATER
+	case lexer.NULLISH:
+		return NULLISH
+	case lexer.PIPE:
+		return BITOR
+	case lexer.CARET:
+		return BITXOR
+	case lexer.AMPERSAND:
+		return BITAND
+	case lexer.SHL, lexer.SHR:
+		return SHIFT
 	case lexer.PLUS, lexer.MINUS:
 		return SUM
-	case lexer.SLASH, lexer.ASTERISK:
+	case lexer.SLASH, lexer.ASTERISK, lexer.PERCENT:
 		return PRODUCT
+	case lexer.POWER:
+		return POWER
 	case lexer.LPAREN:
 		return CALL
-	case lexer.LBRACKET:
+	case lexer.LBRACKET, lexer.DOT, lexer.QUESTION_DOT:
 		return INDEX
 	default:
 		return LOWEST
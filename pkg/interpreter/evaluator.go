@@ -1,7 +1,10 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/alwaifu/monkey/pkg/ast"
 	"github.com/alwaifu/monkey/pkg/object"
@@ -11,81 +14,143 @@ var (
 	TRUE  = object.True
 	FALSE = object.False
 	NULL  = object.NULL
+
+	BREAK    = &object.LoopControl{Break: true}
+	CONTINUE = &object.LoopControl{Break: false}
 )
 
+// Eval 使用标准内置函数在环境env中对node求值, 结果为*object.Error时表示执行出错
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return EvalContext(context.Background(), node, env, nil)
+}
+
+// EvalContext 使用注册表b中的内置函数在环境env中对node求值, b为nil时使用标准内置函数.
+// ctx取消时中止执行并返回包含ctx.Err()信息的错误
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, b *object.Builtins) object.Object {
+	if b == nil {
+		b = object.StandardBuiltins()
+	}
+	return evalContext{ctx: ctx, builtins: b}.evalNode(node, env)
+}
+
+// evalNode 求值并为错误标记位置
+func (c evalContext) evalNode(node ast.Node, env *object.Environment) object.Object {
+	result := c.eval(node, env)
+	// 错误由最内层产生错误的节点标记位置, 外层节点原样传递
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func (c evalContext) eval(node ast.Node, env *object.Environment) object.Object {
+	chainLink := c.chainLink
+	c.chainLink = false
 	var result object.Object
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		return c.evalProgram(node.Statements, env)
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return c.evalBlockStatement(node, env)
 	// statment
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return c.evalNode(node.Expression, env)
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if val.Type() == object.ERROR_OBJ {
+		val := c.evalNode(node.Value, env)
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if val.Type() == object.ERROR_OBJ {
+		val := c.evalNode(node.ReturnValue, env)
+		if isAbrupt(val) {
 			return val // fail fast
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.WhileStatement:
+		return c.evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return c.evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	// expression
 	case *ast.IntegerLiteral:
 		return object.Integer(node.Value)
+	case *ast.FloatLiteral:
+		return object.Float(node.Value)
 	case *ast.BooleanLiteral:
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.NullLiteral:
+		return NULL
 	case *ast.StringLiteral:
 		return object.String(node.Value)
+	case *ast.TemplateLiteral:
+		var out strings.Builder
+		for _, part := range node.Parts {
+			value := c.evalNode(part, env)
+			if isAbrupt(value) {
+				return value
+			}
+			out.WriteString(value.Inspect())
+		}
+		return object.String(out.String())
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if right.Type() == object.ERROR_OBJ {
+		right := c.evalNode(node.Right, env)
+		if isAbrupt(right) {
 			return right // fail fast
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if left.Type() == object.ERROR_OBJ {
+		left := c.evalNode(node.Left, env)
+		if isAbrupt(left) {
 			return left // fail fast
 		}
-		// FIXME: Implement short circuiting. See: https://en.wikipedia.org/wiki/Short-circuit_evaluation
-		right := Eval(node.Right, env)
-		if right.Type() == object.ERROR_OBJ {
+		if node.Operator == "and" || node.Operator == "or" {
+			return c.evalLogicalExpression(node.Operator, left, node.Right, env)
+		}
+		if node.Operator == "??" {
+			if left.Type() != object.NULL_OBJ {
+				return left
+			}
+			return c.evalNode(node.Right, env)
+		}
+		right := c.evalNode(node.Right, env)
+		if isAbrupt(right) {
 			return right // fail fast
 		}
 		return evalInfixExpression(node.Operator, left, right)
 	case *ast.IfExpression:
-		condition := Eval(node.Condition, env)
-		if condition.Type() == object.ERROR_OBJ {
+		condition := c.evalNode(node.Condition, env)
+		if isAbrupt(condition) {
 			return condition // fail fast
 		}
 		if isTruthy(condition) {
-			return Eval(node.Consequence, env)
+			return c.evalNode(node.Consequence, env)
 		} else if node.Alternative != nil {
-			return Eval(node.Alternative, env)
+			return c.evalNode(node.Alternative, env)
 		} else {
 			return NULL
 		}
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return c.evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
 		return &object.Function{Parameters: params, Body: body, Env: env}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if function.Type() == object.ERROR_OBJ {
+		function := c.evalChainObject(node.Function, env)
+		if function == skipChain {
+			return skip(chainLink)
+		}
+		if isAbrupt(function) {
 			return function
 		}
 		args := make([]object.Object, 0, len(node.Arguments))
 		for _, e := range node.Arguments {
-			evaluated := Eval(e, env)
-			if evaluated.Type() == object.ERROR_OBJ {
+			evaluated := c.evalNode(e, env)
+			if isAbrupt(evaluated) {
 				return evaluated
 			}
 			args = append(args, evaluated)
@@ -94,31 +159,68 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		// if len(args) == 1 && args[0].Type() == object.ERROR_OBJ {
 		// 	return args[0]
 		// }
-		return applyFunction(function, args)
+		return c.applyFunction(function, args)
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && elements[0].Type() == object.ERROR_OBJ {
+		elements := c.evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
+	case *ast.HashLiteral:
+		return c.evalHashLiteral(node, env)
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if left.Type() == object.ERROR_OBJ {
+		left := c.evalChainObject(node.Left, env)
+		if left == skipChain {
+			return skip(chainLink)
+		}
+		if isAbrupt(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
-		if index.Type() == object.ERROR_OBJ {
+		index := c.evalNode(node.Index, env)
+		if isAbrupt(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
+	case *ast.SliceExpression:
+		left := c.evalChainObject(node.Left, env)
+		if left == skipChain {
+			return skip(chainLink)
+		}
+		if isAbrupt(left) {
+			return left
+		}
+		// 省略的部分为null
+		bounds := []object.Object{NULL, NULL, NULL}
+		for i, e := range []ast.Expression{node.Start, node.End, node.Step} {
+			if e == nil {
+				continue
+			}
+			if bounds[i] = c.evalNode(e, env); isAbrupt(bounds[i]) {
+				return bounds[i]
+			}
+		}
+		return object.Slice(left, bounds[0], bounds[1], bounds[2])
+	case *ast.MemberExpression:
+		obj := c.evalChainObject(node.Object, env)
+		if obj == skipChain || node.Optional && obj.Type() == object.NULL_OBJ {
+			return skip(chainLink)
+		}
+		if isAbrupt(obj) {
+			return obj
+		}
+		return object.Member(obj, node.Property.Value, node.Optional)
+	case *ast.AssignExpression:
+		return c.evalAssignExpression(node, env)
+	case *ast.MatchExpression:
+		return c.evalMatchExpression(node, env)
 	}
 	return result
 }
 
-func evalProgram(statements []ast.Statement, env *object.Environment) object.Object {
+func (c evalContext) evalProgram(statements []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range statements {
-		result = Eval(statement, env)
+		result = c.evalNode(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
@@ -128,24 +230,77 @@ func evalProgram(statements []ast.Statement, env *object.Environment) object.Obj
 	}
 	return result
 }
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (c evalContext) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
-		if result != nil {
-			if rt := result.Type(); rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result // 对比evalProgram函数 此处不解包return_value, 直接传递给外层来中断外层语句块
-			}
+		result = c.evalNode(statement, env)
+		if result != nil && isAbrupt(result) {
+			return result // 对比evalProgram函数 此处不解包return_value, 直接传递给外层来中断外层语句块
 		}
 	}
+	if result == nil {
+		return NULL // 语句块为空或者以let语句结尾
+	}
 	return result
 }
+func (c evalContext) evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := c.evalNode(node.Condition, env)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+		if result, stop := c.evalLoopBody(node.Body, env); stop {
+			return result
+		}
+	}
+}
+func (c evalContext) evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := c.evalNode(node.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	iter := object.NewIterator(iterable)
+	if iter == nil {
+		return newError("not iterable: %s", iterable.Type())
+	}
+	for value, ok := iter.Next(); ok; value, ok = iter.Next() {
+		env.Set(node.Variable.Value, value)
+		if result, stop := c.evalLoopBody(node.Body, env); stop {
+			return result
+		}
+	}
+	return NULL
+}
+
+// evalLoopBody 执行一次循环体, 遇到break, return, 错误或者执行被取消时stop为true, 循环结果为result
+func (c evalContext) evalLoopBody(body *ast.BlockStatement, env *object.Environment) (result object.Object, stop bool) {
+	if err := c.Err(); err != nil {
+		return newError("%s", err), true
+	}
+	switch result := c.evalNode(body, env).(type) {
+	case *object.LoopControl:
+		if result.Break {
+			return NULL, true
+		}
+	case *object.ReturnValue, *object.Error:
+		return result, true
+	}
+	return nil, false
+}
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		if i, ok := right.(object.Integer); ok {
+			return ^i
+		}
+		return newError("unknown operator: ~%s", right.Type())
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -163,16 +318,22 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 	}
 }
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case object.Integer:
+		return -right
+	case object.Float:
+		return -right
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(object.Integer)
-	return -value
 }
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left.(object.Integer), right.(object.Integer))
+	case isNumber(left) && isNumber(right) && !isBitwiseOperator(operator):
+		// 整数与浮点数混合运算时, 整数转换为浮点数
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left.(object.String), right.(object.String))
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
@@ -196,7 +357,34 @@ func evalIntegerInfixExpression(operator string, left, right object.Integer) obj
 	case "*":
 		return left * right
 	case "/":
+		if right == 0 {
+			return newError("division by zero")
+		}
 		return left / right
+	case "%":
+		if right == 0 {
+			return newError("modulo by zero")
+		}
+		return left % right
+	case "**":
+		if right < 0 {
+			return object.Float(math.Pow(float64(left), float64(right)))
+		}
+		return left.Pow(right)
+	case "&":
+		return left & right
+	case "|":
+		return left | right
+	case "^":
+		return left ^ right
+	case "<<", ">>":
+		if right < 0 {
+			return newError("negative shift count: %d", right)
+		}
+		if operator == "<<" {
+			return left << right
+		}
+		return left >> right
 	case "<":
 		return nativeBoolToBooleanObject(left < right)
 	case "<=":
@@ -213,8 +401,46 @@ func evalIntegerInfixExpression(operator string, left, right object.Integer) obj
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
+func evalFloatInfixExpression(operator string, left, right object.Float) object.Object {
+	switch operator {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "/":
+		return left / right
+	case "%":
+		return object.Float(math.Mod(float64(left), float64(right)))
+	case "**":
+		return object.Float(math.Pow(float64(left), float64(right)))
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return newError("unknown operator: %s %s %s", object.FLOAT_OBJ, operator, object.FLOAT_OBJ)
+	}
+}
 func evalStringInfixExpression(operator string, left, right object.String) object.Object {
 	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
 	case "==":
 		return nativeBoolToBooleanObject(left == right)
 	case "!=":
@@ -232,19 +458,30 @@ func evalBooleanInfixExpression(operator string, left, right object.Boolean) obj
 		return nativeBoolToBooleanObject(left == right)
 	case "!=":
 		return nativeBoolToBooleanObject(left != right)
-	case "and":
-		return nativeBoolToBooleanObject(isTruthy(left) && isTruthy(right))
-	case "or":
-		return nativeBoolToBooleanObject(isTruthy(left) || isTruthy(right))
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
-func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+
+// evalLogicalExpression 短路求值and/or, 左操作数可以决定结果时不再对右操作数求值, 结果总是布尔值
+func (c evalContext) evalLogicalExpression(operator string, left object.Object, rightNode ast.Expression, env *object.Environment) object.Object {
+	if operator == "and" && !isTruthy(left) {
+		return FALSE
+	}
+	if operator == "or" && isTruthy(left) {
+		return TRUE
+	}
+	right := c.evalNode(rightNode, env)
+	if isAbrupt(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+func (c evalContext) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
-	} else if buitin := object.GetBuiltinByName(node.Value); buitin != nil {
-		return buitin
+	} else if builtin, ok := c.builtins.Get(node.Value); ok {
+		return builtin
 	} else {
 		return newError("identifier not found: " + node.Value)
 	}
@@ -252,46 +489,309 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		// 负数从末尾计数, 越界时返回错误
 		arrayObject := left.(*object.Array)
-		idx := int64(index.(object.Integer))
-		if idx < 0 || idx >= int64(len(arrayObject.Elements)) { // TODO: 数组索引负数&超出数组长度
-			return NULL
+		idx, ok := arrayObject.Index(int(index.(object.Integer)))
+		if !ok {
+			return newError("array index out of bounds: %d", index)
 		}
 		return arrayObject.Elements[idx]
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		// 按码点索引
+		if str, ok := left.(object.String).At(int(index.(object.Integer))); ok {
+			return str
+		}
+		return newError("string index out of bounds: %d", index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left.(*object.Hash), index)
+	case left.Type() == object.RECORD_OBJ:
+		return left.(*object.Record).Index(index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (c evalContext) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var old object.Object
+		if node.Operator != "=" {
+			if old = c.evalIdentifier(target, env); isAbrupt(old) {
+				return old
+			}
+		}
+		val := c.evalAssignValue(node, old, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if !env.Assign(target.Value, val) {
+			return newError("identifier not found: " + target.Value)
+		}
+		return val
+	case *ast.IndexExpression:
+		left := c.evalNode(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := c.evalNode(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		var old object.Object
+		if node.Operator != "=" {
+			if old = evalIndexExpression(left, index); isAbrupt(old) {
+				return old
+			}
+		}
+		val := c.evalAssignValue(node, old, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if err := setIndex(left, index, val); err != nil {
+			return err
+		}
+		return val
+	case *ast.MemberExpression:
+		// a.b = c 等价于 a["b"] = c
+		left := c.evalNode(target.Object, env)
+		if isAbrupt(left) {
+			return left
+		}
+		var old object.Object
+		if node.Operator != "=" {
+			if old = object.Member(left, target.Property.Value, false); isAbrupt(old) {
+				return old
+			}
+		}
+		val := c.evalAssignValue(node, old, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if err := setIndex(left, object.String(target.Property.Value), val); err != nil {
+			return err
+		}
+		return val
+	default:
+		return newError("invalid assignment target %s", node.Target)
+	}
+}
+
+// evalAssignValue 计算赋值表达式右侧的值, 复合赋值时与原来的值old进行运算
+func (c evalContext) evalAssignValue(node *ast.AssignExpression, old object.Object, env *object.Environment) object.Object {
+	val := c.evalNode(node.Value, env)
+	if isAbrupt(val) || node.Operator == "=" {
+		return val
+	}
+	return evalInfixExpression(strings.TrimSuffix(node.Operator, "="), old, val)
+}
+func setIndex(left, index, val object.Object) *object.Error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arrayObject := left.(*object.Array)
+		idx, ok := arrayObject.Index(int(index.(object.Integer)))
+		if !ok {
+			return newError("array index out of bounds: %d", index)
+		}
+		arrayObject.Elements[idx] = val
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key, val)
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+	return nil
+}
+func (c evalContext) evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	value := c.evalNode(node.Value, env)
+	if isAbrupt(value) {
+		return value
+	}
+	for _, arm := range node.Arms {
+		bindings := map[string]object.Object{}
+		if !c.matchPattern(arm.Pattern, value, bindings) {
+			continue
+		}
+		// 与let一致, 模式中绑定的变量定义在当前环境中
+		for name, v := range bindings {
+			env.Set(name, v)
+		}
+		if arm.Guard != nil {
+			guard := c.evalNode(arm.Guard, env)
+			if isAbrupt(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return c.evalNode(arm.Body, env)
+	}
+	return NULL
+}
+
+// matchPattern 判断value是否匹配模式, 匹配时模式中的变量保存在bindings中
+func (c evalContext) matchPattern(pattern ast.Expression, value object.Object, bindings map[string]object.Object) bool {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			bindings[pattern.Value] = value
+		}
+		return true
+	case *ast.ArrayLiteral:
+		arr, ok := value.(*object.Array)
+		if !ok || len(arr.Elements) != len(pattern.Elements) {
+			return false
+		}
+		for i, element := range pattern.Elements {
+			if !c.matchPattern(element, arr.Elements[i], bindings) {
+				return false
+			}
+		}
+		return true
+	case *ast.HashLiteral:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for i, key := range pattern.Keys {
+			v, ok := hash.Get(c.eval(key, nil).(object.Hashable))
+			if !ok || !c.matchPattern(pattern.Values[i], v, bindings) {
+				return false
+			}
+		}
+		return true
+	default:
+		// 字面量模式只匹配类型和值都相同的值, 1不匹配1.0
+		literal := c.eval(pattern, nil).(object.Hashable)
+		v, ok := value.(object.Hashable)
+		return ok && literal.Type() == v.Type() && literal.HashKey() == v.HashKey()
+	}
+}
+func evalHashIndexExpression(hash *object.Hash, index object.Object) object.Object {
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+	if value, ok := hash.Get(key); ok {
+		return value
+	}
+	return NULL
+}
+func (c evalContext) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	hash := object.NewHash(len(node.Keys))
+	for i, keyNode := range node.Keys {
+		key := c.evalNode(keyNode, env)
+		if isAbrupt(key) {
+			return key
+		}
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+		value := c.evalNode(node.Values[i], env)
+		if isAbrupt(value) {
+			return value
+		}
+		hash.Set(hashKey, value)
+	}
+	return hash
+}
+func (c evalContext) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	result := make([]object.Object, 0, len(exps))
 	for _, e := range exps {
-		evaluated := Eval(e, env)
-		if evaluated.Type() == object.ERROR_OBJ {
+		evaluated := c.evalNode(e, env)
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
 	}
 	return result
 }
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (c evalContext) applyFunction(fn object.Object, args []object.Object) object.Object {
+	if err := c.Err(); err != nil {
+		return newError("%s", err)
+	}
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
 		env := object.NewEnclosedEnvironment(fn.Env)
 		for i, param := range fn.Parameters {
 			env.Set(param.Value, args[i])
 		}
-		evaluated := Eval(fn.Body, env)
-		// FIXME: 这里需要解包
-		// if evaluated, ok := evaluated.(*object.ReturnValue); ok {
-		// 	return evaluated.Value
-		// }
+		evaluated := c.evalNode(fn.Body, env)
+		// 解包return_value, 避免return继续中断调用方的语句块
+		if evaluated, ok := evaluated.(*object.ReturnValue); ok {
+			return evaluated.Value
+		}
+		if evaluated == nil {
+			return NULL // 函数体为空或者以let语句结尾
+		}
 		return evaluated
 	case *object.Builtin:
-		return fn.Fn(args...)
+		return fn.Call(c, args...)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
+
+// evalContext 一次求值的上下文, 同时作为内置函数的执行上下文, 回调时重新进入applyFunction
+type evalContext struct {
+	ctx      context.Context
+	builtins *object.Builtins
+
+	chainLink bool // 求值的节点是成员访问, 索引, 切片或调用表达式的对象部分
+}
+
+var _ object.Context = evalContext{}
+
+func (c evalContext) Call(fn object.Object, args ...object.Object) object.Object {
+	return c.applyFunction(fn, args)
+}
+
+// Err ctx取消后返回ctx.Err()
+func (c evalContext) Err() error {
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	default:
+		return nil
+	}
+}
+
+// evalChainObject 对链中表达式的对象部分(a.b中的a, a(b)中的a)求值, 它与外层表达式属于同一个链
+func (c evalContext) evalChainObject(node ast.Expression, env *object.Environment) object.Object {
+	c.chainLink = true
+	return c.evalNode(node, env)
+}
+
+// chainSkip a?.b遇到null时跳过链(a?.b.c(d)[e])中剩余的成员访问, 索引, 切片和调用
+type chainSkip struct{}
+
+func (chainSkip) Type() object.ObjectType { return object.NULL_OBJ }
+func (chainSkip) Inspect() string         { return "null" }
+
+var skipChain object.Object = chainSkip{}
+
+// skip 链被跳过时, 在链的内部继续向外传递skipChain, 链最外层的表达式的值为null
+func skip(chainLink bool) object.Object {
+	if chainLink {
+		return skipChain
+	}
+	return NULL
+}
+
+// isAbrupt 错误, return和break/continue中断外层表达式的求值, 原样向外传递直到所在的函数或循环
+func isAbrupt(obj object.Object) bool {
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.LOOP_CONTROL_OBJ:
+		return true
+	}
+	return false
+}
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -304,6 +804,25 @@ func isTruthy(obj object.Object) bool {
 		return true
 	}
 }
+
+// isBitwiseOperator 只能用于整数的运算符
+func isBitwiseOperator(operator string) bool {
+	switch operator {
+	case "&", "|", "^", "<<", ">>":
+		return true
+	}
+	return false
+}
+func isNumber(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ
+}
+func toFloat(obj object.Object) object.Float {
+	if i, ok := obj.(object.Integer); ok {
+		return object.Float(i)
+	}
+	return obj.(object.Float)
+}
 func nativeBoolToBooleanObject(input bool) object.Boolean {
 	if input {
 		return TRUE
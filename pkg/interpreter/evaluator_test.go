@@ -1,7 +1,9 @@
 package interpreter
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/alwaifu/monkey/pkg/ast"
 	"github.com/alwaifu/monkey/pkg/lexer"
@@ -60,12 +62,33 @@ func TestErrorHandling(t *testing.T) {
 		{
 			"foobar", "identifier not found: foobar",
 		},
-		// TODO: implement hash
-		// {
-		// 	`{"name": "Monkey"}[fn(x) { x }];`,
-		// 	"unusable as hash key: FUNCTION",
-		// },
+		{
+			`{"name": "Monkey"}[fn(x) { x }];`,
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`{[1]: 2}`,
+			"unusable as hash key: ARRAY",
+		},
 		{`999[1]`, "index operator not supported: INTEGER"},
+		{`1.5 - "a"`, "type mismatch: FLOAT - STRING"},
+		{"10 / (5 - 5)", "division by zero"},
+		{"10 % 0", "modulo by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"1 & 1.5", "type mismatch: INTEGER & FLOAT"},
+		{"1.5 | 2.5", "unknown operator: FLOAT | FLOAT"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+		{"for (x in true) {}", "not iterable: BOOLEAN"},
+		{"range(1, 2, 0)", "range step must not be zero"},
+		{"fn(a, b) { a }(1)", "wrong number of arguments: want=2, got=1"},
+		{`int("x")`, `cannot convert "x" to INTEGER`},
+		{`float(true)`, "argument to `float` not supported, got BOOLEAN"},
+		{"x = 1", "identifier not found: x"},
+		{`"x${1 + true}"`, "type mismatch: INTEGER + BOOLEAN"},
+		{"let a = [1]; a[1] = 2", "array index out of bounds: 1"},
+		{`let s = "a"; s[0] = "b"`, "index assignment not supported: STRING"},
+		{"let h = {}; h[[1]] = 1", "unusable as hash key: ARRAY"},
+		{`let x = 1; x += "a"`, "type mismatch: INTEGER + STRING"},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -99,6 +122,21 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"12345 % 100", 45},
+		{"2 ** 10", 1024},
+		{"-2 ** 2", -4},
+		{"2 ** 3 ** 2", 512},
+		{"3 ** 0", 1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"1 << 2 + 1", 8},
+		{"5 & 1 + 2", 1},
 	}
 
 	for _, tt := range tests {
@@ -106,6 +144,43 @@ func TestEvalIntegerExpression(t *testing.T) {
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"7 / 2.0", 3.5},
+		{"0.1 * 10 - 1", 0.0},
+		{"int(2.9)", 2},
+		{`int("-7")`, -7},
+		{`float("1e3")`, 1000.0},
+		{"float(3)", 3.0},
+		{"1.5 > 1", true},
+		{"1 <= 0.5", false},
+		{"1 == 1.0", true},
+		{"2.0 != 2", false},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			result, ok := evaluated.(object.Float)
+			if !ok {
+				t.Errorf("input %q: object is not Float. got=%T (%+v)", tt.input, evaluated, evaluated)
+			} else if float64(result) != expected {
+				t.Errorf("input %q: object has wrong value. got=%g, want=%g", tt.input, result, expected)
+			}
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	result, ok := obj.(object.Integer)
 	if !ok {
@@ -190,6 +265,9 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", nil},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -283,6 +361,32 @@ func TestClosures(t *testing.T) {
 		addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) { return 0; }
+			if (x == 1) { return 1; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+		{`
+		let wrapper = fn() {
+			let fib = fn(n) {
+				if (n < 2) { return n; }
+				fib(n - 1) + fib(n - 2);
+			};
+			fib(15);
+		};
+		wrapper();`, 610},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World"`
 	evaluated := testEval(input)
@@ -303,6 +407,12 @@ func TestStringOperate(t *testing.T) {
 		{input: `"Hello" == "World"`, expected: object.Boolean(false)},
 		{input: `"Hello" == "Hello"`, expected: object.Boolean(true)},
 		{input: `"Hello" != "World"`, expected: object.Boolean(true)},
+		{input: `"a\tb\n"`, expected: object.String("a\tb\n")},
+		{input: "`C:\\dir\n${x}`", expected: object.String("C:\\dir\n${x}")},
+		{input: `let name = "monkey"; "Hello ${name}!"`, expected: object.String("Hello monkey!")},
+		{input: `let a = 1; let b = 2.5; "${a} + ${b} = ${a + b}"`, expected: object.String("1 + 2.5 = 3.5")},
+		{input: `"${[1, "x"]} ${true} ${if (false) { 1 }}"`, expected: object.String("[1, x] true null")},
+		{input: `"${"nested ${1 + 1}"}"`, expected: object.String("nested 2")},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -328,6 +438,242 @@ func TestArrayLiterals(t *testing.T) {
 	testIntegerObject(t, result.Elements[1], 4)
 	testIntegerObject(t, result.Elements[2], 6)
 }
+func TestUnicodeStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("价格")`, 2},
+		{`len(bytes("价格"))`, 6},
+		{`"价格"[1]`, "格"},
+		{`"héllo"[1] + "héllo"[4]`, "éo"},
+		{`"价格"[-1]`, "格"},
+		{`"价格"[-2]`, "价"},
+		{`bytes("é")[0] + bytes("é")[1]`, 195 + 169},
+		{"let 价格 = 10; let 数量 = 3; 价格 * 数量", 30},
+		{`let r = ""; for (c in "价格") { let r = c + r }; r`, "格价"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if str, ok := evaluated.(object.String); !ok || string(str) != expected {
+				t.Errorf("input %q: wrong result. want=%q, got=%v", tt.input, expected, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+func TestArrayBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"first([1, 2, 3])", "1"},
+		{"range(5)", "range(0, 5)"},
+		{"range(1, 10, 3)", "range(1, 10, 3)"},
+		{"[len(range(0, 10, 3)), len(range(5, 0)), len(range(10, 0, -3)), len(range(1 << 40))]", "[4, 0, 4, 1099511627776]"},
+		{"map(range(3), fn(x) { x * 2 })", "[0, 2, 4]"},
+		{"[contains(range(0, 10, 2), 4), contains(range(0, 10, 2), 5)]", "[true, false]"},
+		{"let s = 0; for (i in range(10, 0, -3)) { s += i }; s", "22"},
+		{"let n = 0; for (i in range(1 << 40)) { if (i == 3) { break } n += 1 }; n", "3"},
+		{"let s = []; for (i in range(9223372036854775805, 9223372036854775807)) { s = push(s, i) }; s", "[9223372036854775805, 9223372036854775806]"},
+		{"map(range(1 << 40), fn(x) { x })", "ERROR: 1:4: range too large, limit is 16777216 elements"},
+		{"first([])", "null"},
+		{"last([1, 2, 3])", "3"},
+		{"rest([1, 2, 3])", "[2, 3]"},
+		{"rest([])", "null"},
+		{"let a = [1]; push(a, 2, 3); push(a, 2)", "[1, 2]"},
+		{"let a = [1, 2]; pop(a); a", "[1, 2]"},
+		{"pop([1, 2])", "[1]"},
+		{"slice([1, 2, 3, 4], 1, 3)", "[2, 3]"},
+		{"slice([1, 2, 3], 1)", "[2, 3]"},
+		{`slice("价格表", 1)`, "格表"},
+		{"slice([1, 2, 3], -2)", "[2, 3]"},
+		{"slice([1, 2], 2, 1)", "[]"},
+		{"concat([1], [], [2, 3])", "[1, 2, 3]"},
+		{"reverse([1, 2, 3])", "[3, 2, 1]"},
+		{"contains([1, 2], 2.0)", "true"},
+		{`contains(["a"], "b")`, "false"},
+		{`index_of([1, "a"], "a")`, "1"},
+		{"index_of([1], 2)", "-1"},
+		{"sort([3, 1.5, 2])", "[1.5, 2, 3]"},
+		{`sort(["b", "c", "a"])`, "[a, b, c]"},
+		{`sort_by(["ccc", "a", "bb"], fn(s) { len(s) })`, "[a, bb, ccc]"},
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{"let k = 10; map([1, 2], fn(x) { x + k })", "[11, 12]"},
+		{"filter(range(10), fn(x) { x % 3 == 0 })", "[0, 3, 6, 9]"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc + x })", "6"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc * x }, 10)", "60"},
+		{"reduce([], fn(acc, x) { acc + x })", "null"},
+		{"any([false, 0])", "true"},
+		{"any([false])", "false"},
+		{"any([1, 2], fn(x) { x > 1 })", "true"},
+		{"all([1, 2], fn(x) { x > 1 })", "false"},
+		{"all([])", "true"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+		{"map([1], len)", "ERROR: 1:4: argument to `len` not supported, got INTEGER"},
+		{"map([1, 0], fn(x) { 1 / x })", "ERROR: 1:23: division by zero"},
+		{"map([1], fn(a, b) { a })", "ERROR: 1:4: wrong number of arguments: want=2, got=1"},
+		{"sort([1, \"a\"])", "ERROR: 1:5: cannot sort INTEGER with STRING"},
+		{"slice([1, 2], \"a\")", "ERROR: 1:6: slice indices must be INTEGER, got STRING"},
+		{"first(1)", "ERROR: 1:6: argument to `first` must be ARRAY, got INTEGER"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`contains("Mozilla/5.0 (iPhone)", "iPhone")`, "true"},
+		{`contains("abc", "d")`, "false"},
+		{`starts_with("Mozilla/5.0", "Mozilla")`, "true"},
+		{`ends_with("a@example.com", "@example.org")`, "false"},
+		{`split("a,b,,c", ",")`, "[a, b, , c]"},
+		{`split("  a  b ")`, "[a, b]"},
+		{`join(["a", 1, true], "-")`, "a-1-true"},
+		{`trim("  a b \n")`, "a b"},
+		{`trim("xxaxx", "x")`, "a"},
+		{`upper("abc")`, "ABC"},
+		{`lower("ÀB")`, "àb"},
+		{`replace("a.b.c", ".", "/")`, "a/b/c"},
+		{`substr("价格表", 1)`, "格表"},
+		{`substr("价格表", 1, 1)`, "格"},
+		{`substr("abc", 2, 10)`, "c"},
+		{`substr("abc", 5)`, ""},
+		{`format("%s=%d (%.1f) %v", "x", 1, 2.25, [1, "a"])`, "x=1 (2.2) [1 a]"},
+		{`sprintf("%v", fn(x) { x })`, "fn(x) x"},
+		{`matches("bob@example.com", "^[a-z]+@example\\.com$")`, "true"},
+		{`matches("Bob@example.com", "^[a-z]+@")`, "false"},
+		{`find_all("a1b22c333", "[0-9]+")`, "[1, 22, 333]"},
+		{`find_all("abc", "[0-9]")`, "[]"},
+		{`replace_re("2024-01-02", "(\\d+)-(\\d+)-(\\d+)", "$3/$2/$1")`, "02/01/2024"},
+		{`contains("abc", 1)`, "ERROR: 1:9: argument to `contains` must be STRING, got INTEGER"},
+		{`contains(1, 1)`, "ERROR: 1:9: argument to `contains` not supported, got INTEGER"},
+		{`upper(1)`, "ERROR: 1:6: argument to `upper` must be STRING, got INTEGER"},
+		{`substr("abc", -1)`, "ERROR: 1:7: negative argument to `substr`: -1"},
+		{`matches("a", "(")`, "ERROR: 1:8: invalid regular expression \"(\": error parsing regexp: missing closing ): `(`"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+func TestCustomBuiltins(t *testing.T) {
+	builtins := object.NewBuiltins()
+	err := builtins.Register("twice", 2, "twice(fn, x) returns fn(fn(x))", func(c object.Context, args ...object.Object) object.Object {
+		x := c.Call(args[0], args[1])
+		if x.Type() == object.ERROR_OBJ {
+			return x
+		}
+		return c.Call(args[0], x)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 宿主的值和自定义内置函数一起使用
+	env, err := object.NewEnviromentFromMap(map[string]interface{}{"n": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"twice(fn(x) { x * 3 }, 2)", "18"},
+		{"let f = fn() { twice(fn(x) { x + 1 }, 0) }; f()", "2"},
+		{"twice(fn(x) { x })", "ERROR: 1:6: wrong number of arguments. got=1, want=2"},
+		{"len([])", "ERROR: 1:1: identifier not found: len"},
+		{"twice(fn(x) { x * n }, 2)", "18"},
+	}
+	for _, tt := range tests {
+		program := ast.NewParser(lexer.NewLexer(tt.input)).ParseProgram()
+		if got := EvalContext(context.Background(), program, env, builtins).Inspect(); got != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+func TestEvalContextCancel(t *testing.T) {
+	tests := []string{
+		"while (true) {}",
+		"let f = fn(n) { f(n + 1) }; f(0)",
+		"map([1, 2], fn(x) { while (true) { x += 1 } })",
+	}
+	for _, input := range tests {
+		program := ast.NewParser(lexer.NewLexer(input)).ParseProgram()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		got := EvalContext(ctx, program, object.NewEnviroment(), nil)
+		cancel()
+		if err, ok := got.(*object.Error); !ok || err.Message != context.DeadlineExceeded.Error() {
+			t.Errorf("input %q: expected deadline exceeded error, got %s", input, got.Inspect())
+		}
+	}
+}
+
+// canceledContext 已取消的执行上下文, 记录回调次数
+type canceledContext struct{ calls int }
+
+func (c *canceledContext) Call(fn object.Object, args ...object.Object) object.Object {
+	c.calls++
+	return args[0]
+}
+func (c *canceledContext) Err() error { return context.Canceled }
+
+func TestBuiltinsCheckCancel(t *testing.T) {
+	// 循环调用回调的内置函数在执行被取消后不再回调
+	builtins := object.StandardBuiltins()
+	arr := &object.Array{Elements: []object.Object{object.Integer(1), object.Integer(2)}}
+	for _, name := range []string{"map", "filter", "reduce", "sort_by", "any", "all"} {
+		builtin, _ := builtins.Get(name)
+		c := &canceledContext{}
+		got := builtin.Call(c, arr, builtin)
+		if err, ok := got.(*object.Error); !ok || err.Message != context.Canceled.Error() || c.calls != 0 {
+			t.Errorf("%s: expected canceled error without callback, got %s (calls=%d)", name, got.Inspect(), c.calls)
+		}
+	}
+}
+func TestHostValues(t *testing.T) {
+	type item struct {
+		Name  string  `monkey:"name"`
+		Price float64 `monkey:"price"`
+	}
+	env, err := object.NewEnviromentFromMap(map[string]interface{}{
+		"items":  []item{{"a", 1.5}, {"b", 2}},
+		"prefix": func(s string, n int) string { return s[:n] },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`reduce(map(items, fn(x) { x["price"] }), fn(a, b) { a + b })`, "3.5"},
+		{`prefix(items[1]["name"] + "cd", 2)`, "bc"},
+		{`items[0]`, "item{name: a, price: 1.5}"},
+		{`items[0]["qty"]`, "ERROR: 1:9: unknown field qty of item"},
+		{`prefix(1, 2)`, "ERROR: 1:7: argument 1: cannot convert INTEGER to string"},
+		{`items[1].name + items[0].price`, "ERROR: 1:15: type mismatch: STRING + FLOAT"},
+		{`items[1].price * 2`, "4.0"},
+		{`items[0].qty`, "ERROR: 1:9: unknown field qty of item"},
+		{`items[0]?.qty ?? 0`, "0"},
+	}
+	for _, tt := range tests {
+		program := ast.NewParser(lexer.NewLexer(tt.input)).ParseProgram()
+		if got := Eval(program, env).Inspect(); got != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
 func TestArrayIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -366,12 +712,12 @@ func TestArrayIndexExpressions(t *testing.T) {
 			2,
 		},
 		{
-			"[1, 2, 3][3]",
-			nil,
+			"[1, 2, 3][-1]",
+			3,
 		},
 		{
-			"[1, 2, 3][-1]",
-			nil,
+			"[1, 2, 3][-3]",
+			1,
 		},
 	}
 	for _, tt := range tests {
@@ -384,6 +730,46 @@ func TestArrayIndexExpressions(t *testing.T) {
 		}
 	}
 }
+func TestSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1, 2, 3][3]", "ERROR: 1:10: array index out of bounds: 3"},
+		{"[1, 2, 3][-4]", "ERROR: 1:10: array index out of bounds: -4"},
+		{`"价格"[2]`, "ERROR: 1:9: string index out of bounds: 2"},
+		{"let a = [1, 2, 3]; a[-1] = 4; a", "[1, 2, 4]"},
+		{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+		{"[1, 2, 3, 4][:2]", "[1, 2]"},
+		{"[1, 2, 3, 4][2:]", "[3, 4]"},
+		{"[1, 2, 3, 4][:]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4][-3:-1]", "[2, 3]"},
+		{"[1, 2, 3, 4][::2]", "[1, 3]"},
+		{"[1, 2, 3, 4][1::2]", "[2, 4]"},
+		{"[1, 2, 3, 4][::-1]", "[4, 3, 2, 1]"},
+		{"[1, 2, 3, 4][2::-1]", "[3, 2, 1]"},
+		{"[1, 2, 3, 4][:0:-2]", "[4, 2]"},
+		{"[1, 2, 3, 4][-10:10]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4][3:1]", "[]"},
+		{"[1, 2, 3, 4][::100]", "[1]"},
+		{"[1, 2, 3, 4][::-100]", "[4]"},
+		{"[][::-1]", "[]"},
+		{`"hello, 世界"[:5]`, "hello"},
+		{`"hello, 世界"[-2:]`, "世界"},
+		{`"价格表"[::-1]`, "表格价"},
+		{"let a = [1, 2]; let b = a[:]; b[0] = 3; a", "[1, 2]"},
+		{"let h = {\"xs\": [1, 2, 3]}; h.xs[1:][0]", "2"},
+		{"[1, 2][::0]", "ERROR: 1:7: slice step cannot be zero"},
+		{`[1, 2]["a":]`, "ERROR: 1:7: slice indices must be INTEGER, got STRING"},
+		{"1[1:]", "ERROR: 1:2: slice operator not supported: INTEGER"},
+		{"[1][1 / 0:]", "ERROR: 1:7: division by zero"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
 func TestLogicalOperators(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -409,9 +795,264 @@ func TestLogicalOperators(t *testing.T) {
 		{"1 < 2 or 3 < 4", true},
 		{"1 < 2 and 3 < 4", true},
 		{"1 < 2 and 3 > 4", false},
+		{"1 and \"a\"", true},
+		{"0 or false", true},
+		{"false or if (false) { 1 }", false},
+		{"false and undefined", false},
+		{"true or undefined", true},
+		{"let x = [1]; x != [] and x[0] > 0", true},
+		{"let f = fn() { 1 / 0 }; false and f() or true", true},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let s = 0; for (x in [1, 2, 3]) { let s = s + x }; s", 6},
+		{`let r = ""; for (c in "héllo") { let r = c + r }; r`, "olléh"},
+		{`let r = ""; for (k in {"a": 1, "b": 2}) { let r = r + k }; r`, "ab"},
+		{"let s = 0; for (i in range(5)) { let s = s + i }; s", 10},
+		{"let s = 0; for (i in range(100000)) { let s = s + i }; s", int64(4999950000)},
+		{"let s = 0; for (i in range(10)) { if (i == 5) { break }; if (i == 2) { continue }; let s = s + i }; s", 8},
+		{"let s = 0; for (i in range(3)) { for (j in range(3)) { if (j > i) { break } let s = s + 1 } }; s", 6},
+		{"let i = 0; while (i < 5) { let i = i + 1 }; i", 5},
+		{"let i = 0; while (true) { let i = i + 1; if (i > 3) { break } }; i", 4},
+		{"let find = fn(xs, t) { for (x in xs) { if (x == t) { return true } } false }; find([1, 2], 2)", true},
+		{"let find = fn(xs, t) { for (x in xs) { if (x == t) { return true } } false }; find([1, 2], 3)", false},
+		{"let f = fn(n) { let s = 0; let i = 0; while (i < n) { let i = i + 1; if (i == 2) { continue } let s = s + i }; s }; f(4)", 8},
+		{"let f = fn() { for (x in [1]) { x } }; f()", nil},
+		{"if (true) { while (false) { 1 } }", nil},
+		{"while (true) { break }", nil},
+		{"for (x in []) { x }", nil},
+		{"let s = 0; for (i in [1, 2, 3]) { s = s + [1, if (i == 2) { continue } else { i }][1] }; s", 4},
+		{"let s = 0; for (i in [1, 2, 3]) { s = s + [1, if (i == 2) { break } else { i }][1] }; s", 1},
+		{"let i = 0; while (i < 3) { i += 1; let x = -(if (i > 1) { break } else { i }) }; i", 2},
+		{"let f = fn() { 1 + if (true) { return 5 } }; f()", 5},
+		{"[if (true) {}][0]", nil},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case int64:
+			testIntegerObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if str, ok := evaluated.(object.String); !ok || string(str) != expected {
+				t.Errorf("input %q: wrong result. want=%q, got=%v", tt.input, expected, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; let y = 2; x = y = 3; x + y", 6},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let x = 1.5; x *= 2; x", 3.0},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let s = 0; for (i in range(5)) { s += i }; s", 10},
+		{"let i = 0; while (i < 3) { i = i + 1 }; i", 3},
+		{"let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n", 2},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[2] += 10; a[2]", 13},
+		{"let a = [[1], [2]]; a[1][0] *= 3; a[1][0]", 6},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"a": 1}; h["a"] -= 1; h["a"]`, 0},
+		{`let h = {}; h[true] = "yes"; h[true]`, "yes"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			if f, ok := evaluated.(object.Float); !ok || float64(f) != expected {
+				t.Errorf("input %q: wrong result. want=%v, got=%v", tt.input, expected, evaluated)
+			}
+		case string:
+			if str, ok := evaluated.(object.String); !ok || string(str) != expected {
+				t.Errorf("input %q: wrong result. want=%q, got=%v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (5) { 1 => "one", 2 => "two", _ => "many" }`, "many"},
+		{`match (-1) { -1 => "minus one", 1 => "one" }`, "minus one"},
+		{`match (1.0) { 1 => "int", 1.0 => "float" }`, "float"},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{`match (false) { true => 1, false => 0 }`, 0},
+		{"match (3) { 1 => 1 }", nil},
+		{"match (3) { n => n * 2 }", 6},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b, _ => 0 }", 3},
+		{"match ([1, [2, 3]]) { [a, [_, c]] => a + c }", 4},
+		{"match ([1, 2, 3]) { [a, b] => 0, _ => -1 }", -1},
+		{`match ({"x": 1, "y": 2}) { {"x": x, "z": z} => 0, {"x": x, "y": 2} => x }`, 1},
+		{`match ({"type": "circle", "r": 2}) { {"type": "square", "a": a} => a * a, {"type": "circle", "r": r} => 3 * r * r }`, 12},
+		{`match ("x") { {} => 1, [] => 2, _ => 3 }`, 3},
+		{"match (7) { n if n < 5 => 1, n if n < 10 => 2, _ => 3 }", 2},
+		{"match ([3, 1]) { [a, b] if a < b => b, [a, b] => a }", 3},
+		{"let x = 1; match (2) { x => x }; x", 2},
+		{"let f = fn(n) { match (n) { 0 => 1, _ => n * f(n - 1) } }; f(5)", 120},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if str, ok := evaluated.(object.String); !ok || string(str) != expected {
+				t.Errorf("input %q: wrong result. want=%q, got=%v", tt.input, expected, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+	}
+	expected := map[object.HashKey]int64{
+		object.String("one").HashKey():   1,
+		object.String("two").HashKey():   2,
+		object.String("three").HashKey(): 3,
+		object.Integer(4).HashKey():      4,
+		TRUE.HashKey():                   5,
+		FALSE.HashKey():                  6,
+	}
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	}
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+	if got, want := result.Inspect(), "{one: 1, two: 2, three: 3, 4: 4, true: 5, false: 6}"; got != want {
+		t.Errorf("Hash.Inspect wrong. got=%q, want=%q", got, want)
+	}
+}
+func TestMemberExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let order = {"customer": {"country": "NZ"}}; order.customer.country`, "NZ"},
+		{`let h = {"a": 1}; h.b`, "null"},
+		{`let m = {"double": fn(x) { x * 2 }}; m.double(21)`, "42"},
+		{`let h = {"match": 1}; h.match`, "1"},
+		{`let h = {}; h?.a?.b`, "null"},
+		{`let h = {}; h.a.b`, "ERROR: 1:16: member access not supported: NULL"},
+		{`let h = {}; h.a?.b.c`, "null"},
+		{`let h = {}; h.a?.b(1)[0]`, "null"},
+		{`let h = {}; h.a?.b + 1`, "ERROR: 1:20: type mismatch: NULL + INTEGER"},
+		{`let order = {}.order; order?.customer.country ?? "unknown"`, "unknown"},
+		{`[1].length`, "ERROR: 1:4: member access not supported: ARRAY"},
+		{`let h = {"a": {}}; h.a.b = 1; h.a.b += 2; h.a`, "{b: 3}"},
+		{`let h = {}; h.a = 1`, "1"},
+		{`let x = 0; x ?? 5`, "0"},
+		{`let h = {}; h.a ?? 5`, "5"},
+		{`let h = {"a": false}; h.a ?? 5`, "false"},
+		{`let h = {}; h.a ?? h.b ?? "c"`, "c"},
+		{`let f = fn() { 1 / 0 }; 1 ?? f()`, "1"},
+		{`let h = {}; h.a ?? 0 > 5`, "false"},
+		{`null`, "null"},
+		{`let h = {"a": null}; [h.a == null, h.b == null, 0 == null, null != false]`, "[true, true, false, true]"},
+		{`let h = {}; h.a ?? null`, "null"},
+		{`null ?? 1`, "1"},
+		{`null?.a`, "null"},
+		{`null.a`, "ERROR: 1:5: member access not supported: NULL"},
+		{`if (null) { 1 } else { 2 }`, "2"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("input %q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+		{`len({"a": 1, "b": 2, "a": 3})`, 2},
+		{`{1: 5}[1.0]`, 5},
+		{`{2.0: 5}[2]`, 5},
+		{`{1.5: 5}[1.5]`, 5},
+		{`{1: 5}[1.5]`, nil},
+		{`len({1: 1, 1.0: 2, -0.0: 3, 0: 4})`, 2},
+		{`{1: 1, 1.0: 2}[1]`, 2},
+		{`let h = {1.0: 1}; h[1] = 2; h[1.0]`, 2},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\nlet b = \"x\";\na + b", "rules.mk:3:3: type mismatch: INTEGER + STRING"},
+		{"let f = fn(x) {\n  x + \"a\"\n};\nf(1)", "rules.mk:2:5: type mismatch: INTEGER + STRING"},
+		{"1;\n  foobar", "rules.mk:2:3: identifier not found: foobar"},
+		{"len(1)", "rules.mk:1:4: argument to `len` not supported, got INTEGER"},
+		{"let f = fn(x) {\n  1 / x\n};\nmap([1, 0], f)", "rules.mk:2:5: division by zero"},
+	}
+	for _, tt := range tests {
+		program := ast.NewParser(lexer.NewFileLexer("rules.mk", tt.input)).ParseProgram()
+		errObj, ok := Eval(program, object.NewEnviroment()).(*object.Error)
+		if !ok {
+			t.Fatalf("input: %s, no error object returned", tt.input)
+		}
+		if errObj.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Error())
+		}
+	}
+}
@@ -40,8 +40,8 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\t")
+func printErrors(out io.Writer, errors ast.ErrorList) {
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.Error()+"\n")
 	}
 }
@@ -2,24 +2,63 @@ package lexer
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
 	input        string
-	position     int
-	readPosition int
-	ch           byte
+	position     int  // byte offset of ch
+	readPosition int  // byte offset of next rune
+	ch           rune // current rune, 0 at EOF
+
+	file      string
+	line      int // line of ch
+	lineStart int // byte offset of first rune in current line
+
+	keepComments bool  // 返回COMMENT词法单元而不是跳过注释
+	templates    []int // 尚未结束的字符串插值`${}`中未闭合的`{`数量, 用于找到插值结束的`}`
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFileLexer("", input)
+}
+
+// NewFileLexer 创建词法分析器, 生成的词法单元位置信息中会带上文件名
+func NewFileLexer(file, input string) *Lexer {
+	l := &Lexer{input: input, file: file, line: 1}
 	l.readChar()
+	if l.ch == '#' && l.peekChar() == '!' {
+		// skip shebang line, so script file can be executed directly
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	}
 	return l
 }
 
+// KeepComments 使NextToken返回注释, 供格式化工具和文档生成工具保留注释, 默认跳过注释
+func (l *Lexer) KeepComments() {
+	l.keepComments = true
+}
+
 func (l *Lexer) NextToken() Token {
 	var tok Token
 	l.skipWhitespace()
+	for l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*') {
+		pos := l.pos()
+		comment, ok := l.readComment()
+		if !ok {
+			return Token{Type: ILLEGAL, Literal: comment, Pos: pos, Err: "unterminated comment"}
+		}
+		if l.keepComments {
+			return Token{Type: COMMENT, Literal: comment, Pos: pos}
+		}
+		l.skipWhitespace()
+	}
+	pos := l.pos()
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -27,13 +66,26 @@ func (l *Lexer) NextToken() Token {
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = Token{Type: EQ, Literal: literal}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: ARROW, Literal: "=>"}
 		} else {
 			tok = Token{Type: ASSIGN, Literal: string(l.ch)}
 		}
 	case '+':
-		tok = Token{Type: PLUS, Literal: string(l.ch)}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = Token{Type: PLUS_ASSIGN, Literal: "+="}
+		} else {
+			tok = Token{Type: PLUS, Literal: string(l.ch)}
+		}
 	case '-':
-		tok = Token{Type: MINUS, Literal: string(l.ch)}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = Token{Type: MINUS_ASSIGN, Literal: "-="}
+		} else {
+			tok = Token{Type: MINUS, Literal: string(l.ch)}
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -44,15 +96,31 @@ func (l *Lexer) NextToken() Token {
 			tok = Token{Type: BANG, Literal: string(l.ch)}
 		}
 	case '*':
-		tok = Token{Type: ASTERISK, Literal: string(l.ch)}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = Token{Type: ASTERISK_ASSIGN, Literal: "*="}
+		} else if l.peekChar() == '*' {
+			l.readChar()
+			tok = Token{Type: POWER, Literal: "**"}
+		} else {
+			tok = Token{Type: ASTERISK, Literal: string(l.ch)}
+		}
 	case '/':
-		tok = Token{Type: SLASH, Literal: string(l.ch)}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = Token{Type: SLASH_ASSIGN, Literal: "/="}
+		} else {
+			tok = Token{Type: SLASH, Literal: string(l.ch)}
+		}
 	case '<':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = Token{Type: LE, Literal: literal}
+		} else if l.peekChar() == '<' {
+			l.readChar()
+			tok = Token{Type: SHL, Literal: "<<"}
 		} else {
 			tok = Token{Type: LT, Literal: string(l.ch)}
 		}
@@ -62,17 +130,42 @@ func (l *Lexer) NextToken() Token {
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = Token{Type: GE, Literal: literal}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: SHR, Literal: ">>"}
 		} else {
 			tok = Token{Type: GT, Literal: string(l.ch)}
 		}
+	case '%':
+		tok = Token{Type: PERCENT, Literal: string(l.ch)}
+	case '&':
+		tok = Token{Type: AMPERSAND, Literal: string(l.ch)}
+	case '|':
+		tok = Token{Type: PIPE, Literal: string(l.ch)}
+	case '^':
+		tok = Token{Type: CARET, Literal: string(l.ch)}
+	case '~':
+		tok = Token{Type: TILDE, Literal: string(l.ch)}
 	case '(':
 		tok = Token{Type: LPAREN, Literal: string(l.ch)}
 	case ')':
 		tok = Token{Type: RPAREN, Literal: string(l.ch)}
 	case '{':
+		if n := len(l.templates); n > 0 {
+			l.templates[n-1]++
+		}
 		tok = Token{Type: LBRACE, Literal: string(l.ch)}
 	case '}':
-		tok = Token{Type: RBRACE, Literal: string(l.ch)}
+		if n := len(l.templates); n > 0 && l.templates[n-1] == 0 {
+			// 插值表达式结束, 继续读取字符串剩余的部分
+			l.templates = l.templates[:n-1]
+			tok = l.readStringToken(TEMPLATE_TAIL, TEMPLATE_MIDDLE)
+		} else {
+			if n > 0 {
+				l.templates[n-1]--
+			}
+			tok = Token{Type: RBRACE, Literal: string(l.ch)}
+		}
 	case '[':
 		tok = Token{Type: LBRACKET, Literal: string(l.ch)}
 	case ']':
@@ -81,8 +174,24 @@ func (l *Lexer) NextToken() Token {
 		tok = Token{Type: COMMA, Literal: string(l.ch)}
 	case ';':
 		tok = Token{Type: SEMICOLON, Literal: string(l.ch)}
+	case ':':
+		tok = Token{Type: COLON, Literal: string(l.ch)}
+	case '.':
+		tok = Token{Type: DOT, Literal: string(l.ch)}
+	case '?':
+		if l.peekChar() == '.' {
+			l.readChar()
+			tok = Token{Type: QUESTION_DOT, Literal: "?."}
+		} else if l.peekChar() == '?' {
+			l.readChar()
+			tok = Token{Type: NULLISH, Literal: "??"}
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: string(l.ch), Err: fmt.Sprintf("illegal character %q", l.ch)}
+		}
 	case '"':
-		tok = Token{Type: STRING, Literal: l.readString()}
+		tok = l.readStringToken(STRING, TEMPLATE_HEAD)
+	case '`':
+		tok = l.readRawString()
 	case 0:
 		tok.Literal = ""
 		tok.Type = EOF
@@ -90,35 +199,53 @@ func (l *Lexer) NextToken() Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = LookupIdent(tok.Literal)
+			tok.Pos = pos
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = pos
 			return tok
 		} else {
-			tok = Token{Type: ILLEGAL, Literal: string(l.ch)}
+			literal := l.input[l.position:l.readPosition]
+			if l.ch == utf8.RuneError && len(literal) == 1 {
+				tok = Token{Type: ILLEGAL, Literal: literal, Err: "invalid UTF-8 encoding"}
+			} else {
+				tok = Token{Type: ILLEGAL, Literal: literal, Err: fmt.Sprintf("illegal character %q", l.ch)}
+			}
 		}
 	}
 	l.readChar()
+	tok.Pos = pos
 	return tok
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return r
 }
 
+// readChar 读取下一个字符(按UTF-8解码), 非法的UTF-8编码读取为utf8.RuneError
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+	l.position = l.readPosition
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+		return
 	}
-	l.position = l.readPosition
-	l.readPosition += 1
+	r, width := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	l.ch = r
+	l.readPosition += width
+}
+
+// pos 当前字符的位置, 列号按字节计数
+func (l *Lexer) pos() Position {
+	return Position{File: l.file, Offset: l.position, Line: l.line, Column: l.position - l.lineStart + 1}
 }
 func (l *Lexer) readIdentifier() string {
 	position := l.position
@@ -127,35 +254,175 @@ func (l *Lexer) readIdentifier() string {
 	}
 	return l.input[position:l.position]
 }
-func (l *Lexer) readNumber() string {
+
+// readNumber 读取整数或浮点数(3.14, 1e-9, 2.5E+3)
+func (l *Lexer) readNumber() (string, TokenType) {
 	position := l.position
+	typ := INT
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		typ = FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		// 指数部分必须带数字, 否则e作为后续标识符的开始
+		next := l.peekChar()
+		if (next == '+' || next == '-') && l.readPosition+1 < len(l.input) {
+			next = rune(l.input[l.readPosition+1])
+		}
+		if isDigit(next) {
+			typ = FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+	return l.input[position:l.position], typ
+}
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
-func (l *Lexer) readString() string {
+
+// readStringToken 读取字符串字面量, 开始时l.ch为开头的`"`或者结束插值的`}`.
+// 字符串在`"`处结束时返回end类型的词法单元, 在`${`处结束时返回interp类型的词法单元,
+// 词法单元的Literal为解码转义字符之后的字符串内容
+func (l *Lexer) readStringToken(end, interp TokenType) Token {
+	position := l.position
+	value, interpolation, err := l.readString()
+	if interpolation {
+		l.templates = append(l.templates, 0)
+	}
+	if err != "" {
+		return Token{Type: ILLEGAL, Literal: l.input[position:min(l.position+1, len(l.input))], Err: err}
+	}
+	if interpolation {
+		return Token{Type: interp, Literal: value}
+	}
+	return Token{Type: end, Literal: value}
+}
+
+// readString 读取字符串内容直到`"`或者`${`, 结束时l.ch为`"`或者`${`中的`{`.
+// 遇到错误时记录第一个错误并继续读取到字符串结束, 使后续的词法单元不受影响
+func (l *Lexer) readString() (value string, interpolation bool, err string) {
+	var out strings.Builder
+	fail := func(format string, a ...interface{}) {
+		if err == "" {
+			err = fmt.Sprintf(format, a...)
+		}
+	}
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			fail("unterminated string")
+			return out.String(), false, err
+		case '"':
+			return out.String(), false, err
+		case '$':
+			if l.peekChar() == '{' {
+				l.readChar()
+				return out.String(), true, err
+			}
+			out.WriteByte('$')
+		case '\\':
+			l.readChar()
+			switch l.ch {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case '"', '\\', '$':
+				out.WriteRune(l.ch)
+			case 'u':
+				r, ok := l.readUnicodeEscape()
+				if !ok {
+					fail("invalid unicode escape sequence")
+				}
+				out.WriteRune(r)
+			case 0:
+				fail("unterminated string")
+				return out.String(), false, err
+			default:
+				fail("invalid escape sequence \\%c", l.ch)
+			}
+		default:
+			out.WriteString(l.input[l.position:l.readPosition]) // 保留非法的UTF-8编码
+		}
+	}
+}
+
+// readUnicodeEscape 读取\u{XXXX}中的`{XXXX}`部分, 开始时l.ch为u, 结束时l.ch为`}`
+func (l *Lexer) readUnicodeEscape() (rune, bool) {
+	if l.peekChar() != '{' {
+		return utf8.RuneError, false
+	}
+	l.readChar()
 	position := l.position + 1
+	for l.peekChar() != '}' && l.peekChar() != '"' && l.peekChar() != 0 {
+		l.readChar()
+	}
+	digits := l.input[position : l.position+1]
+	if l.peekChar() != '}' || len(digits) == 0 || len(digits) > 6 {
+		return utf8.RuneError, false
+	}
+	l.readChar()
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || !utf8.ValidRune(rune(code)) {
+		return utf8.RuneError, false
+	}
+	return rune(code), true
+}
+
+// readRawString 读取反引号括起来的原始字符串, 可以跨行, 不处理转义和插值
+func (l *Lexer) readRawString() Token {
+	position := l.position
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case 0:
+			return Token{Type: ILLEGAL, Literal: l.input[position:], Err: "unterminated raw string"}
+		case '`':
+			return Token{Type: STRING, Literal: l.input[position+1 : l.position]}
 		}
-		if l.ch == '\\' {
+	}
+}
+
+// readComment 读取`//`行注释(不包括换行符)或`/* */`块注释, 块注释没有结束时ok为false
+func (l *Lexer) readComment() (comment string, ok bool) {
+	position := l.position
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
 			l.readChar()
-			continue
 		}
+		return l.input[position:l.position], true
+	}
+	l.readChar()
+	l.readChar()
+	for l.ch != 0 {
+		if l.ch == '*' && l.peekChar() == '/' {
+			l.readChar()
+			l.readChar()
+			return l.input[position:l.position], true
+		}
+		l.readChar()
 	}
-	return fmt.Sprint(l.input[position:l.position])
+	return l.input[position:l.position], false
 }
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
 	}
 }
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' || ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
@@ -93,7 +93,7 @@ let result = add(five, ten);
 }
 
 func TestOperatorToken(t *testing.T) {
-	input := `!-/*5;
+	input := `!-/ *5;
 5 < 10 > 5;
 `
 	tests := []struct {
@@ -124,3 +124,283 @@ func TestOperatorToken(t *testing.T) {
 		}
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	input := `{"foo": "bar", 1: true}`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{LBRACE, "{"},
+		{STRING, "foo"},
+		{COLON, ":"},
+		{STRING, "bar"},
+		{COMMA, ","},
+		{INT, "1"},
+		{COLON, ":"},
+		{TRUE, "true"},
+		{RBRACE, "}"},
+		{EOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	input := `5 3.14 1e-9 2.5E+3 7e 1.foo 10.`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{INT, "5"},
+		{FLOAT, "3.14"},
+		{FLOAT, "1e-9"},
+		{FLOAT, "2.5E+3"},
+		{INT, "7"},
+		{IDENT, "e"},
+		{INT, "1"},
+		{DOT, "."},
+		{IDENT, "foo"},
+		{INT, "10"},
+		{DOT, "."},
+		{EOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestAssignOperators(t *testing.T) {
+	input := `x = 1; x += 2; x -= 3; x *= 4; x /= 5; x==6`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{IDENT, "x"}, {ASSIGN, "="}, {INT, "1"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {PLUS_ASSIGN, "+="}, {INT, "2"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {MINUS_ASSIGN, "-="}, {INT, "3"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {ASTERISK_ASSIGN, "*="}, {INT, "4"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {SLASH_ASSIGN, "/="}, {INT, "5"}, {SEMICOLON, ";"},
+		{IDENT, "x"}, {EQ, "=="}, {INT, "6"},
+		{EOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	input := `a % b ** c & d | e ^ ~f << 1 >> 2 <= 3 * 4`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{IDENT, "a"}, {PERCENT, "%"}, {IDENT, "b"}, {POWER, "**"}, {IDENT, "c"},
+		{AMPERSAND, "&"}, {IDENT, "d"}, {PIPE, "|"}, {IDENT, "e"}, {CARET, "^"}, {TILDE, "~"}, {IDENT, "f"},
+		{SHL, "<<"}, {INT, "1"}, {SHR, ">>"}, {INT, "2"}, {LE, "<="}, {INT, "3"}, {ASTERISK, "*"}, {INT, "4"},
+		{EOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q), got=%q(%q)", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestMatchTokens(t *testing.T) {
+	input := `match (x) { [a, _] if a => 1, _ => 2 }`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{MATCH, "match"}, {LPAREN, "("}, {IDENT, "x"}, {RPAREN, ")"}, {LBRACE, "{"},
+		{LBRACKET, "["}, {IDENT, "a"}, {COMMA, ","}, {IDENT, "_"}, {RBRACKET, "]"},
+		{IF, "if"}, {IDENT, "a"}, {ARROW, "=>"}, {INT, "1"}, {COMMA, ","},
+		{IDENT, "_"}, {ARROW, "=>"}, {INT, "2"}, {RBRACE, "}"},
+		{EOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q), got=%q(%q)", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestMemberTokens(t *testing.T) {
+	input := `a.b?.c ?? d ? e ?? null`
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{IDENT, "a"}, {DOT, "."}, {IDENT, "b"}, {QUESTION_DOT, "?."}, {IDENT, "c"},
+		{NULLISH, "??"}, {IDENT, "d"}, {ILLEGAL, "?"}, {IDENT, "e"}, {NULLISH, "??"}, {NULL, "null"},
+		{EOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q), got=%q(%q)", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestTemplateTokens(t *testing.T) {
+	input := `"a${x}b${ {"k": "${y}"}["k"] }c" "\n" ` + "`raw\n\\n`"
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TEMPLATE_HEAD, "a"}, {IDENT, "x"}, {TEMPLATE_MIDDLE, "b"},
+		{LBRACE, "{"}, {STRING, "k"}, {COLON, ":"}, {TEMPLATE_HEAD, ""}, {IDENT, "y"}, {TEMPLATE_TAIL, ""}, {RBRACE, "}"},
+		{LBRACKET, "["}, {STRING, "k"}, {RBRACKET, "]"}, {TEMPLATE_TAIL, "c"},
+		{STRING, "\n"}, {STRING, "raw\n\\n"},
+		{EOF, ""},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q), got=%q(%q)", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestUnicodeIdentifiers(t *testing.T) {
+	input := "let 价格 = \"价格\"; αβ_γ +\n é \xff"
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+		expectedPos     string
+	}{
+		{LET, "let", "1:1"}, {IDENT, "价格", "1:5"}, {ASSIGN, "=", "1:12"}, {STRING, "价格", "1:14"}, {SEMICOLON, ";", "1:22"},
+		{IDENT, "αβ_γ", "1:24"}, {PLUS, "+", "1:32"},
+		{IDENT, "é", "2:2"}, {ILLEGAL, "\xff", "2:5"},
+		{EOF, "", "2:6"},
+	}
+	l := NewLexer(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q) at %s, got=%q(%q) at %s", i, tt.expectedType, tt.expectedLiteral, tt.expectedPos, tok.Type, tok.Literal, tok.Pos)
+		}
+	}
+	if tok := NewLexer("\xff").NextToken(); tok.Err != "invalid UTF-8 encoding" {
+		t.Errorf("wrong error for invalid UTF-8. got=%q", tok.Err)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// header\nlet x = 10 / 2; // ten halves\n/* block\n * comment */ x /* inline */ /= 1\n// end"
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+		expectedPos     string
+	}{
+		{COMMENT, "// header", "1:1"},
+		{LET, "let", "2:1"}, {IDENT, "x", "2:5"}, {ASSIGN, "=", "2:7"}, {INT, "10", "2:9"},
+		{SLASH, "/", "2:12"}, {INT, "2", "2:14"}, {SEMICOLON, ";", "2:15"},
+		{COMMENT, "// ten halves", "2:17"},
+		{COMMENT, "/* block\n * comment */", "3:1"},
+		{IDENT, "x", "4:15"}, {COMMENT, "/* inline */", "4:17"}, {SLASH_ASSIGN, "/=", "4:30"}, {INT, "1", "4:33"},
+		{COMMENT, "// end", "5:1"},
+		{EOF, "", "5:7"},
+	}
+	l := NewLexer(input)
+	l.KeepComments()
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - wrong token. expected=%q(%q) at %s, got=%q(%q) at %s", i, tt.expectedType, tt.expectedLiteral, tt.expectedPos, tok.Type, tok.Literal, tok.Pos)
+		}
+	}
+
+	// 默认跳过注释
+	l = NewLexer(input)
+	for i, tt := range tests {
+		if tt.expectedType == COMMENT {
+			continue
+		}
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - wrong token. expected=%q at %s, got=%q at %s", i, tt.expectedType, tt.expectedPos, tok.Type, tok.Pos)
+		}
+	}
+
+	l = NewLexer("1 /* never closed")
+	l.NextToken()
+	if tok := l.NextToken(); tok.Type != ILLEGAL || tok.Literal != "/* never closed" || tok.Pos.String() != "1:3" {
+		t.Errorf("wrong token for unterminated comment. got=%q(%q) at %s", tok.Type, tok.Literal, tok.Pos)
+	}
+}
+
+func TestTokenPosition(t *testing.T) {
+	input := "let x = 5;\n  x + \"a b\";\n"
+	tests := []struct {
+		expectedType TokenType
+		expectedPos  string
+		offset       int
+	}{
+		{LET, "rules.mk:1:1", 0},
+		{IDENT, "rules.mk:1:5", 4},
+		{ASSIGN, "rules.mk:1:7", 6},
+		{INT, "rules.mk:1:9", 8},
+		{SEMICOLON, "rules.mk:1:10", 9},
+		{IDENT, "rules.mk:2:3", 13},
+		{PLUS, "rules.mk:2:5", 15},
+		{STRING, "rules.mk:2:7", 17},
+		{SEMICOLON, "rules.mk:2:12", 22},
+		{EOF, "rules.mk:3:1", 24},
+	}
+	l := NewFileLexer("rules.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%q, got=%q", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.Pos.Offset != tt.offset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d, got=%d", i, tt.offset, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	input := "#!/usr/bin/env monkey run\nlet x = 1;"
+	l := NewFileLexer("script.mk", input)
+	tok := l.NextToken()
+	if tok.Type != LET {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", LET, tok.Type)
+	}
+	if tok.Pos.String() != "script.mk:2:1" {
+		t.Fatalf("position wrong. expected=%q, got=%q", "script.mk:2:1", tok.Pos)
+	}
+}
@@ -1,8 +1,35 @@
 package lexer
 
+import "fmt"
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // 词法单元第一个字符在源码中的位置
+	Err     string   // ILLEGAL词法单元的错误原因
+}
+
+// Position 源码位置
+type Position struct {
+	File   string // 文件名, 可以为空
+	Offset int    // 字节偏移量, 从0开始
+	Line   int    // 行号, 从1开始
+	Column int    // 列号, 从1开始, 按字节计数
+}
+
+// IsValid 位置是否有效, 零值表示未知位置
+func (p Position) IsValid() bool { return p.Line > 0 }
+func (p Position) String() string {
+	switch {
+	case !p.IsValid() && p.File == "":
+		return "-"
+	case !p.IsValid():
+		return p.File
+	case p.File == "":
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
 }
 
 type TokenType = string
@@ -10,17 +37,26 @@ type TokenType = string
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // 注释, 只有Lexer.KeepComments时才会返回
 
 	IDENT  = "IDENT"  // 标识符
 	INT    = "INT"    // int字面量
+	FLOAT  = "FLOAT"  // float字面量
 	STRING = "STRING" // string字面量
 
+	// 带插值的字符串 "a${x}b${y}c" 依次为 TEMPLATE_HEAD(a) x TEMPLATE_MIDDLE(b) y TEMPLATE_TAIL(c)
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
 	ASSIGN   = "ASSIGN"   // =
 	PLUS     = "PLUS"     // +
 	MINUS    = "MINUS"    // -
 	BANG     = "BANG"     // !
 	ASTERISK = "ASTERISK" // *
 	SLASH    = "SLASH"    // /
+	PERCENT  = "PERCENT"  // %
+	POWER    = "POWER"    // **
 	LT       = "LT"       // <
 	LE       = "LE"       // <=
 	GT       = "GT"       // >
@@ -30,8 +66,25 @@ const (
 	AND      = "AND"      // and
 	OR       = "OR"       // or
 
-	COMMA     = "," // ,
-	SEMICOLON = ";" // ;
+	AMPERSAND = "AMPERSAND" // &
+	PIPE      = "PIPE"      // |
+	CARET     = "CARET"     // ^
+	TILDE     = "TILDE"     // ~
+	SHL       = "SHL"       // <<
+	SHR       = "SHR"       // >>
+
+	PLUS_ASSIGN     = "PLUS_ASSIGN"     // +=
+	MINUS_ASSIGN    = "MINUS_ASSIGN"    // -=
+	ASTERISK_ASSIGN = "ASTERISK_ASSIGN" // *=
+	SLASH_ASSIGN    = "SLASH_ASSIGN"    // /=
+
+	COMMA        = ","  // ,
+	SEMICOLON    = ";"  // ;
+	COLON        = ":"  // :
+	ARROW        = "=>" // =>
+	DOT          = "."  // .
+	QUESTION_DOT = "?." // ?.
+	NULLISH      = "??" // ??
 
 	LPAREN   = "(" // (
 	RPAREN   = ")" // )
@@ -44,9 +97,16 @@ const (
 	LET      = "LET"      // let
 	TRUE     = "TRUE"     // true
 	FALSE    = "FALSE"    // false
+	NULL     = "NULL"     // null
 	IF       = "IF"       // if
 	ELSE     = "ELSE"     // else
 	RETURN   = "RETURN"   // return
+	WHILE    = "WHILE"    // while
+	FOR      = "FOR"      // for
+	IN       = "IN"       // in
+	BREAK    = "BREAK"    // break
+	CONTINUE = "CONTINUE" // continue
+	MATCH    = "MATCH"    // match
 
 )
 
@@ -60,12 +120,26 @@ func LookupIdent(ident string) TokenType {
 		return TRUE
 	case "false":
 		return FALSE
+	case "null":
+		return NULL
 	case "if":
 		return IF
 	case "else":
 		return ELSE
 	case "return":
 		return RETURN
+	case "while":
+		return WHILE
+	case "for":
+		return FOR
+	case "in":
+		return IN
+	case "break":
+		return BREAK
+	case "continue":
+		return CONTINUE
+	case "match":
+		return MATCH
 	case "and":
 		return AND
 	case "or":
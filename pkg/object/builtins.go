@@ -1,48 +1,915 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
 
-var Builtins = []struct {
-	Name    string
-	Builtin *Builtin
-}{
+// MaxRangeLength 区间(range函数的结果)转换为数组时的最大长度
+const MaxRangeLength = 1 << 24
+
+// MaxBuiltins 注册表中内置函数的数量上限, 虚拟机按一个字节的索引访问内置函数
+const MaxBuiltins = 256
+
+// Builtins 内置函数注册表, 由宿主程序构建后传给编译器和解释器, 各个执行引擎实例互不影响.
+// 编译器创建时复制内置函数列表, 之后修改注册表不影响已编译的字节码; 注册表之间共享*Builtin, 注册后不应修改
+type Builtins struct {
+	list   []*Builtin
+	index  map[string]int
+	shared bool // list和index与标准注册表共享, 修改前需要复制
+}
+
+// NewBuiltins 返回空的注册表
+func NewBuiltins() *Builtins {
+	return &Builtins{index: make(map[string]int)}
+}
+
+// StandardBuiltins 返回包含标准内置函数(len, map, split ...)的新注册表
+func StandardBuiltins() *Builtins {
+	return &Builtins{list: standardBuiltins, index: standardIndex, shared: true}
+}
+
+// own 在修改前复制与标准注册表共享的数据
+func (b *Builtins) own() {
+	if b.shared {
+		b.list, b.index, b.shared = slices.Clone(b.list), maps.Clone(b.index), false
+	}
+}
+
+// Register 注册内置函数, arity为参数个数, 负数表示参数个数可变(由fn自行检查).
+// 同名的内置函数被替换, 超出MaxBuiltins时返回错误
+func (b *Builtins) Register(name string, arity int, doc string, fn BuiltinFunction) error {
+	return b.add(&Builtin{Name: name, Arity: arity, Doc: doc, Fn: fn})
+}
+func (b *Builtins) add(builtin *Builtin) error {
+	if i, ok := b.index[builtin.Name]; ok {
+		b.own()
+		b.list[i] = builtin
+		return nil
+	}
+	if len(b.list) == MaxBuiltins {
+		return fmt.Errorf("too many builtins, limit is %d", MaxBuiltins)
+	}
+	b.own()
+	b.index[builtin.Name] = len(b.list)
+	b.list = append(b.list, builtin)
+	return nil
+}
+
+// Remove 删除内置函数, 之后注册的内置函数位置会改变
+func (b *Builtins) Remove(name string) {
+	i, ok := b.index[name]
+	if !ok {
+		return
+	}
+	b.own()
+	b.list = slices.Delete(b.list, i, i+1)
+	delete(b.index, name)
+	for j := i; j < len(b.list); j++ {
+		b.index[b.list[j].Name] = j
+	}
+}
+
+// Get 按名称查找内置函数
+func (b *Builtins) Get(name string) (*Builtin, bool) {
+	i, ok := b.index[name]
+	if !ok {
+		return nil, false
+	}
+	return b.list[i], true
+}
+
+// At 返回位置i的内置函数
+func (b *Builtins) At(i int) *Builtin { return b.list[i] }
+
+// List 按注册顺序返回内置函数列表的副本, 用于虚拟机的OpGetBuiltin
+func (b *Builtins) List() []*Builtin {
+	list := make([]*Builtin, len(b.list))
+	copy(list, b.list)
+	return list
+}
+
+// Len 返回内置函数的数量
+func (b *Builtins) Len() int { return len(b.list) }
+
+// Range 按注册顺序遍历内置函数, fn返回false时停止
+func (b *Builtins) Range(fn func(i int, builtin *Builtin) bool) {
+	for i, builtin := range b.list {
+		if !fn(i, builtin) {
+			return
+		}
+	}
+}
+
+// standardIndex 标准内置函数名称到位置的映射
+var standardIndex = func() map[string]int {
+	index := make(map[string]int, len(standardBuiltins))
+	for i, builtin := range standardBuiltins {
+		index[builtin.Name] = i
+	}
+	return index
+}()
+
+var standardBuiltins = []*Builtin{
 	{
-		"len",
-		&Builtin{Fn: func(args ...Object) Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1", len(args))
-			}
+		Name:  "len",
+		Arity: 1,
+		Doc:   "len(x) returns the number of code points of a string, or the number of elements of an array, hash or range",
+		Fn: func(_ Context, args ...Object) Object {
 			switch arg := args[0].(type) {
 			case String:
-				return Integer(len(arg))
+				return Integer(arg.Len())
 			case *Array:
 				return Integer(len(arg.Elements))
+			case *Hash:
+				return Integer(arg.Len())
+			case *Range:
+				return Integer(arg.Len())
 			default:
 				return newError("argument to `len` not supported, got %s", args[0].Type())
 			}
-		}},
+		},
 	},
-	// TODO: 添加字符串操作函数(字符串包含, 正则匹配 ...)
 	{
-		"print",
-		&Builtin{Fn: func(args ...Object) Object {
+		Name:  "print",
+		Arity: -1,
+		Doc:   "print(args...) writes args to standard output without separators and returns null",
+		Fn: func(_ Context, args ...Object) Object {
 			for _, arg := range args {
 				fmt.Print(arg.Inspect())
 			}
 			return NULL
-		}},
+		},
+	},
+	{
+		Name:  "int",
+		Arity: 1,
+		Doc:   "int(x) converts a float (truncating) or a decimal string to integer",
+		Fn: func(_ Context, args ...Object) Object {
+			switch arg := args[0].(type) {
+			case Integer:
+				return arg
+			case Float:
+				if math.IsNaN(float64(arg)) || math.IsInf(float64(arg), 0) {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return Integer(arg)
+			case String:
+				i, err := strconv.ParseInt(string(arg), 10, 64)
+				if err != nil {
+					return newError("cannot convert %q to INTEGER", string(arg))
+				}
+				return Integer(i)
+			default:
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name:  "float",
+		Arity: 1,
+		Doc:   "float(x) converts an integer or a string to float",
+		Fn: func(_ Context, args ...Object) Object {
+			switch arg := args[0].(type) {
+			case Integer:
+				return Float(arg)
+			case Float:
+				return arg
+			case String:
+				f, err := strconv.ParseFloat(string(arg), 64)
+				if err != nil {
+					return newError("cannot convert %q to FLOAT", string(arg))
+				}
+				return Float(f)
+			default:
+				return newError("argument to `float` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name:  "str",
+		Arity: 1,
+		Doc:   "str(x) returns the string representation of x",
+		Fn: func(_ Context, args ...Object) Object {
+			return String(args[0].Inspect())
+		},
+	},
+	{
+		Name:  "range",
+		Arity: -1,
+		Doc:   "range(stop), range(start, stop), range(start, stop, step) returns the integers from start (inclusive) to stop (exclusive), produced lazily by for-in",
+		Fn: func(_ Context, args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1..3", len(args))
+			}
+			bounds := []Integer{0, 0, 1}
+			for i, arg := range args {
+				n, ok := arg.(Integer)
+				if !ok {
+					return newError("argument to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds[i] = n
+			}
+			if len(args) == 1 {
+				bounds[0], bounds[1] = 0, bounds[0]
+			}
+			if bounds[2] == 0 {
+				return newError("range step must not be zero")
+			}
+			return &Range{Start: bounds[0], Stop: bounds[1], Step: bounds[2]}
+		},
+	},
+	{
+		Name:  "bytes",
+		Arity: 1,
+		Doc:   "bytes(s) returns the UTF-8 encoding of string s as an array of integers",
+		Fn: func(_ Context, args ...Object) Object {
+			// 字符串的UTF-8编码, 需要按字节访问字符串时使用
+			str, ok := args[0].(String)
+			if !ok {
+				return newError("argument to `bytes` must be STRING, got %s", args[0].Type())
+			}
+			elements := make([]Object, len(str))
+			for i := 0; i < len(str); i++ {
+				elements[i] = Integer(str[i])
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "first",
+		Arity: 1,
+		Doc:   "first(arr) returns the first element of arr, or null if arr is empty",
+		Fn: func(_ Context, args ...Object) Object {
+			arr, err := arrayArgument("first", args[0])
+			if err != nil {
+				return err
+			}
+			if len(arr.Elements) == 0 {
+				return NULL
+			}
+			return arr.Elements[0]
+		},
+	},
+	{
+		Name:  "last",
+		Arity: 1,
+		Doc:   "last(arr) returns the last element of arr, or null if arr is empty",
+		Fn: func(_ Context, args ...Object) Object {
+			arr, err := arrayArgument("last", args[0])
+			if err != nil {
+				return err
+			}
+			if len(arr.Elements) == 0 {
+				return NULL
+			}
+			return arr.Elements[len(arr.Elements)-1]
+		},
+	},
+	{
+		Name:  "rest",
+		Arity: 1,
+		Doc:   "rest(arr) returns a new array without the first element, or null if arr is empty",
+		Fn: func(_ Context, args ...Object) Object {
+			arr, err := arrayArgument("rest", args[0])
+			if err != nil {
+				return err
+			}
+			if len(arr.Elements) == 0 {
+				return NULL
+			}
+			return &Array{Elements: append([]Object{}, arr.Elements[1:]...)}
+		},
+	},
+	{
+		Name:  "push",
+		Arity: -1,
+		Doc:   "push(arr, x...) returns a new array with x appended, arr is not modified",
+		Fn: func(_ Context, args ...Object) Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments. got=%d, want=2+", len(args))
+			}
+			arr, err := arrayArgument("push", args[0])
+			if err != nil {
+				return err
+			}
+			elements := make([]Object, 0, len(arr.Elements)+len(args)-1)
+			elements = append(elements, arr.Elements...)
+			return &Array{Elements: append(elements, args[1:]...)}
+		},
+	},
+	{
+		Name:  "pop",
+		Arity: 1,
+		Doc:   "pop(arr) returns a new array without the last element, or null if arr is empty, arr is not modified",
+		Fn: func(_ Context, args ...Object) Object {
+			arr, err := arrayArgument("pop", args[0])
+			if err != nil {
+				return err
+			}
+			if len(arr.Elements) == 0 {
+				return NULL
+			}
+			return &Array{Elements: append([]Object{}, arr.Elements[:len(arr.Elements)-1]...)}
+		},
+	},
+	{
+		Name:  "slice",
+		Arity: -1,
+		Doc:   "slice(x, start), slice(x, start, end) is x[start:end], the elements of array x, or code points of string x, from start to end",
+		Fn: func(_ Context, args ...Object) Object {
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=2..3", len(args))
+			}
+			end := Object(NULL)
+			if len(args) == 3 {
+				end = args[2]
+			}
+			return Slice(args[0], args[1], end, NULL)
+		},
+	},
+	{
+		Name:  "concat",
+		Arity: -1,
+		Doc:   "concat(arrs...) returns a new array of the elements of all arrs",
+		Fn: func(_ Context, args ...Object) Object {
+			elements := []Object{}
+			for _, arg := range args {
+				arr, err := arrayArgument("concat", arg)
+				if err != nil {
+					return err
+				}
+				elements = append(elements, arr.Elements...)
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "reverse",
+		Arity: 1,
+		Doc:   "reverse(arr) returns a new array with the elements of arr in reverse order",
+		Fn: func(_ Context, args ...Object) Object {
+			arr, err := arrayArgument("reverse", args[0])
+			if err != nil {
+				return err
+			}
+			elements := append([]Object{}, arr.Elements...)
+			slices.Reverse(elements)
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "contains",
+		Arity: 2,
+		Doc:   "contains(arr, x) reports whether array arr contains x, contains(s, sub) reports whether string s contains sub",
+		Fn: func(_ Context, args ...Object) Object {
+			switch arg := args[0].(type) {
+			case *Array, *Range:
+				arr, err := arrayArgument("contains", arg)
+				if err != nil {
+					return err
+				}
+				return Boolean(indexOf(arr.Elements, args[1]) >= 0)
+			case String:
+				sub, ok := args[1].(String)
+				if !ok {
+					return newError("argument to `contains` must be STRING, got %s", args[1].Type())
+				}
+				return Boolean(strings.Contains(string(arg), string(sub)))
+			default:
+				return newError("argument to `contains` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name:  "index_of",
+		Arity: 2,
+		Doc:   "index_of(arr, x) returns the index of the first x in arr, or -1 if x is not present",
+		Fn: func(_ Context, args ...Object) Object {
+			arr, err := arrayArgument("index_of", args[0])
+			if err != nil {
+				return err
+			}
+			return Integer(indexOf(arr.Elements, args[1]))
+		},
+	},
+	{
+		Name:  "sort",
+		Arity: 1,
+		Doc:   "sort(arr) returns a new array of the numbers or strings of arr in ascending order",
+		Fn: func(_ Context, args ...Object) Object {
+			// 稳定排序, 元素须全为数字或全为字符串
+			arr, err := arrayArgument("sort", args[0])
+			if err != nil {
+				return err
+			}
+			elements := append([]Object{}, arr.Elements...)
+			if err := sortStable(elements, elements); err != nil {
+				return err
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "sort_by",
+		Arity: 2,
+		Doc:   "sort_by(arr, fn) returns a new array of the elements of arr in ascending order of fn(x)",
+		Fn: func(c Context, args ...Object) Object {
+			arr, err := arrayArgument("sort_by", args[0])
+			if err != nil {
+				return err
+			}
+			elements := append([]Object{}, arr.Elements...)
+			keys := make([]Object, len(elements))
+			for i, el := range elements {
+				if err := canceled(c); err != nil {
+					return err
+				}
+				if keys[i] = c.Call(args[1], el); isError(keys[i]) {
+					return keys[i]
+				}
+			}
+			if err := sortStable(keys, elements); err != nil {
+				return err
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "map",
+		Arity: 2,
+		Doc:   "map(arr, fn) returns a new array of fn(x) for each element x of arr",
+		Fn: func(c Context, args ...Object) Object {
+			arr, err := arrayArgument("map", args[0])
+			if err != nil {
+				return err
+			}
+			elements := make([]Object, len(arr.Elements))
+			for i, el := range arr.Elements {
+				if err := canceled(c); err != nil {
+					return err
+				}
+				if elements[i] = c.Call(args[1], el); isError(elements[i]) {
+					return elements[i]
+				}
+			}
+			return &Array{Elements: elements}
+		},
 	},
+	{
+		Name:  "filter",
+		Arity: 2,
+		Doc:   "filter(arr, fn) returns a new array of the elements x of arr for which fn(x) is truthy",
+		Fn: func(c Context, args ...Object) Object {
+			arr, err := arrayArgument("filter", args[0])
+			if err != nil {
+				return err
+			}
+			elements := []Object{}
+			for _, el := range arr.Elements {
+				if err := canceled(c); err != nil {
+					return err
+				}
+				ok := c.Call(args[1], el)
+				if isError(ok) {
+					return ok
+				}
+				if isTruthy(ok) {
+					elements = append(elements, el)
+				}
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "reduce",
+		Arity: -1,
+		Doc:   "reduce(arr, fn), reduce(arr, fn, initial) folds arr from left to right with fn(acc, x)",
+		Fn: func(c Context, args ...Object) Object {
+			// 省略initial时以第一个元素为初始值, 空数组返回null
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=2..3", len(args))
+			}
+			arr, err := arrayArgument("reduce", args[0])
+			if err != nil {
+				return err
+			}
+			elements := arr.Elements
+			var acc Object = NULL
+			if len(args) == 3 {
+				acc = args[2]
+			} else if len(elements) > 0 {
+				acc, elements = elements[0], elements[1:]
+			}
+			for _, el := range elements {
+				if err := canceled(c); err != nil {
+					return err
+				}
+				if acc = c.Call(args[1], acc, el); isError(acc) {
+					return acc
+				}
+			}
+			return acc
+		},
+	},
+	{
+		Name:  "any",
+		Arity: -1,
+		Doc:   "any(arr), any(arr, fn) reports whether any element x (or fn(x)) of arr is truthy",
+		Fn: func(c Context, args ...Object) Object {
+			return testElements(c, "any", true, args)
+		},
+	},
+	{
+		Name:  "all",
+		Arity: -1,
+		Doc:   "all(arr), all(arr, fn) reports whether all elements x (or fn(x)) of arr are truthy",
+		Fn: func(c Context, args ...Object) Object {
+			return testElements(c, "all", false, args)
+		},
+	},
+	{
+		Name:  "zip",
+		Arity: -1,
+		Doc:   "zip(arrs...) returns an array of arrays, where the i-th array holds the i-th element of each arr",
+		Fn: func(_ Context, args ...Object) Object {
+			// 结果长度为最短数组的长度
+			if len(args) == 0 {
+				return newError("wrong number of arguments. got=0, want=1+")
+			}
+			arrays := make([]*Array, len(args))
+			length := -1
+			for i, arg := range args {
+				arr, err := arrayArgument("zip", arg)
+				if err != nil {
+					return err
+				}
+				arrays[i] = arr
+				if length < 0 || len(arr.Elements) < length {
+					length = len(arr.Elements)
+				}
+			}
+			elements := make([]Object, length)
+			for i := range elements {
+				tuple := make([]Object, len(arrays))
+				for j, arr := range arrays {
+					tuple[j] = arr.Elements[i]
+				}
+				elements[i] = &Array{Elements: tuple}
+			}
+			return &Array{Elements: elements}
+		},
+	},
+	{
+		Name:  "starts_with",
+		Arity: 2,
+		Doc:   "starts_with(s, prefix) reports whether string s begins with prefix",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("starts_with", args)
+			if err != nil {
+				return err
+			}
+			return Boolean(strings.HasPrefix(strs[0], strs[1]))
+		},
+	},
+	{
+		Name:  "ends_with",
+		Arity: 2,
+		Doc:   "ends_with(s, suffix) reports whether string s ends with suffix",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("ends_with", args)
+			if err != nil {
+				return err
+			}
+			return Boolean(strings.HasSuffix(strs[0], strs[1]))
+		},
+	},
+	{
+		Name:  "split",
+		Arity: -1,
+		Doc:   "split(s) splits s around whitespace, split(s, sep) splits s around sep",
+		Fn: func(_ Context, args ...Object) Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. got=%d, want=1..2", len(args))
+			}
+			strs, err := stringArguments("split", args)
+			if err != nil {
+				return err
+			}
+			if len(strs) == 1 {
+				return stringArray(strings.Fields(strs[0]))
+			}
+			return stringArray(strings.Split(strs[0], strs[1]))
+		},
+	},
+	{
+		Name:  "join",
+		Arity: 2,
+		Doc:   "join(arr, sep) concatenates the elements of arr as strings, separated by sep",
+		Fn: func(_ Context, args ...Object) Object {
+			// 非字符串元素按str转换
+			arr, err := arrayArgument("join", args[0])
+			if err != nil {
+				return err
+			}
+			sep, ok := args[1].(String)
+			if !ok {
+				return newError("argument to `join` must be STRING, got %s", args[1].Type())
+			}
+			elements := make([]string, len(arr.Elements))
+			for i, el := range arr.Elements {
+				elements[i] = el.Inspect()
+			}
+			return String(strings.Join(elements, string(sep)))
+		},
+	},
+	{
+		Name:  "trim",
+		Arity: -1,
+		Doc:   "trim(s) removes leading and trailing whitespace, trim(s, cutset) removes leading and trailing characters in cutset",
+		Fn: func(_ Context, args ...Object) Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. got=%d, want=1..2", len(args))
+			}
+			strs, err := stringArguments("trim", args)
+			if err != nil {
+				return err
+			}
+			if len(strs) == 1 {
+				return String(strings.TrimSpace(strs[0]))
+			}
+			return String(strings.Trim(strs[0], strs[1]))
+		},
+	},
+	{
+		Name:  "upper",
+		Arity: 1,
+		Doc:   "upper(s) returns s with all letters mapped to upper case",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("upper", args)
+			if err != nil {
+				return err
+			}
+			return String(strings.ToUpper(strs[0]))
+		},
+	},
+	{
+		Name:  "lower",
+		Arity: 1,
+		Doc:   "lower(s) returns s with all letters mapped to lower case",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("lower", args)
+			if err != nil {
+				return err
+			}
+			return String(strings.ToLower(strs[0]))
+		},
+	},
+	{
+		Name:  "replace",
+		Arity: 3,
+		Doc:   "replace(s, old, new) returns s with all occurrences of old replaced by new",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("replace", args)
+			if err != nil {
+				return err
+			}
+			return String(strings.ReplaceAll(strs[0], strs[1], strs[2]))
+		},
+	},
+	{
+		Name:  "substr",
+		Arity: -1,
+		Doc:   "substr(s, start), substr(s, start, length) returns the code points of s from start, at most length of them",
+		Fn: func(_ Context, args ...Object) Object {
+			// 超出字符串长度的部分被忽略
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=2..3", len(args))
+			}
+			str, ok := args[0].(String)
+			if !ok {
+				return newError("argument to `substr` must be STRING, got %s", args[0].Type())
+			}
+			runes := []rune(string(str))
+			bounds := []Integer{0, Integer(len(runes))}
+			for i, arg := range args[1:] {
+				n, ok := arg.(Integer)
+				if !ok {
+					return newError("argument to `substr` must be INTEGER, got %s", arg.Type())
+				}
+				if n < 0 {
+					return newError("negative argument to `substr`: %d", n)
+				}
+				bounds[i] = n
+			}
+			start := min(int(bounds[0]), len(runes))
+			end := start + min(int(bounds[1]), len(runes)-start)
+			return String(runes[start:end])
+		},
+	},
+	{Name: "format", Arity: -1, Doc: "format(f, args...) formats args according to the Go fmt verbs in f", Fn: format},
+	{Name: "sprintf", Arity: -1, Doc: "sprintf(f, args...) is an alias of format", Fn: format},
+	{
+		Name:  "matches",
+		Arity: 2,
+		Doc:   "matches(s, re) reports whether s contains any match of regular expression re",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("matches", args)
+			if err != nil {
+				return err
+			}
+			re, err := compileRegexp(strs[1])
+			if err != nil {
+				return err
+			}
+			return Boolean(re.MatchString(strs[0]))
+		},
+	},
+	{
+		Name:  "find_all",
+		Arity: 2,
+		Doc:   "find_all(s, re) returns an array of all successive matches of regular expression re in s",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("find_all", args)
+			if err != nil {
+				return err
+			}
+			re, err := compileRegexp(strs[1])
+			if err != nil {
+				return err
+			}
+			return stringArray(re.FindAllString(strs[0], -1))
+		},
+	},
+	{
+		Name:  "replace_re",
+		Arity: 3,
+		Doc:   "replace_re(s, re, repl) replaces matches of regular expression re in s with repl, where $1 or ${name} expands to the submatch",
+		Fn: func(_ Context, args ...Object) Object {
+			strs, err := stringArguments("replace_re", args)
+			if err != nil {
+				return err
+			}
+			re, err := compileRegexp(strs[1])
+			if err != nil {
+				return err
+			}
+			return String(re.ReplaceAllString(strs[0], strs[2]))
+		},
+	},
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, item := range Builtins {
-		if item.Name == name {
-			return item.Builtin
+// stringArguments 检查参数全部为字符串
+func stringArguments(name string, args []Object) ([]string, *Error) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(String)
+		if !ok {
+			return nil, newError("argument to `%s` must be STRING, got %s", name, arg.Type())
 		}
+		strs[i] = string(str)
 	}
+	return strs, nil
+}
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, s := range strs {
+		elements[i] = String(s)
+	}
+	return &Array{Elements: elements}
+}
+
+// format 按go的fmt格式化参数, 数组和哈希表转换为go原生值, 其他无法转换的对象按str转换
+func format(_ Context, args ...Object) Object {
+	if len(args) < 1 {
+		return newError("wrong number of arguments. got=0, want=1+")
+	}
+	f, ok := args[0].(String)
+	if !ok {
+		return newError("argument to `format` must be STRING, got %s", args[0].Type())
+	}
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		v, err := ToGoValue(arg)
+		if err != nil {
+			v = arg.Inspect()
+		}
+		values[i] = v
+	}
+	return String(fmt.Sprintf(string(f), values...))
+}
+
+// arrayArgument 数组参数, 区间转换为数组
+func arrayArgument(name string, arg Object) (*Array, *Error) {
+	switch arg := arg.(type) {
+	case *Array:
+		return arg, nil
+	case *Range:
+		return arg.Array()
+	default:
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, arg.Type())
+	}
+}
+
+// indexOf 返回元素在数组中的位置, 相等的判断与==一致
+func indexOf(elements []Object, obj Object) int {
+	return slices.IndexFunc(elements, func(el Object) bool {
+		if isNumber(el) && isNumber(obj) {
+			return toFloat(el) == toFloat(obj)
+		}
+		return el == obj
+	})
+}
+
+// sortStable 按keys对keys和elements同时排序, keys须全为数字或全为字符串
+func sortStable(keys, elements []Object) *Error {
+	for _, key := range keys {
+		isString := key.Type() == STRING_OBJ
+		if isString != (keys[0].Type() == STRING_OBJ) || !isString && !isNumber(key) {
+			return newError("cannot sort %s with %s", keys[0].Type(), key.Type())
+		}
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := keys[order[i]], keys[order[j]]
+		if a, ok := a.(String); ok {
+			return a < b.(String)
+		}
+		return toFloat(a) < toFloat(b)
+	})
+	sortedKeys, sortedElements := make([]Object, len(keys)), make([]Object, len(elements))
+	for i, idx := range order {
+		sortedKeys[i], sortedElements[i] = keys[idx], elements[idx]
+	}
+	copy(keys, sortedKeys)
+	copy(elements, sortedElements)
 	return nil
 }
 
-func newError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
+// testElements 实现any和all: 遇到真假性为stopAt的元素时返回stopAt
+func testElements(c Context, name string, stopAt bool, args []Object) Object {
+	if len(args) < 1 || len(args) > 2 {
+		return newError("wrong number of arguments. got=%d, want=1..2", len(args))
+	}
+	arr, err := arrayArgument(name, args[0])
+	if err != nil {
+		return err
+	}
+	for _, el := range arr.Elements {
+		if err := canceled(c); err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			if el = c.Call(args[1], el); isError(el) {
+				return el
+			}
+		}
+		if isTruthy(el) == stopAt {
+			return Boolean(stopAt)
+		}
+	}
+	return Boolean(!stopAt)
+}
+
+// canceled 执行被取消时返回错误, 循环调用回调的内置函数在每次迭代前检查
+func canceled(c Context) *Error {
+	if err := c.Err(); err != nil {
+		return newError("%s", err)
+	}
+	return nil
+}
+
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case Boolean:
+		return bool(obj)
+	case Null:
+		return false
+	default:
+		return true
+	}
+}
+func isError(obj Object) bool {
+	return obj.Type() == ERROR_OBJ
+}
+func isNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+func toFloat(obj Object) Float {
+	if i, ok := obj.(Integer); ok {
+		return Float(i)
+	}
+	return obj.(Float)
 }
@@ -1,44 +1,121 @@
 package object
 
-import "errors"
+import (
+	"errors"
+	"reflect"
+)
 
 func NewEnviromentFromMap(dir map[string]interface{}) (*Environment, error) {
 	store := make(map[string]Object, len(dir))
 	for k, v := range dir {
-		switch v := v.(type) {
-		case bool:
-			store[k] = Boolean(v)
-		case int:
-			store[k] = Integer(v)
-		case int64:
-			store[k] = Integer(v)
-		case string:
-			store[k] = String(v)
-		default:
-			return nil, errors.New("invalid type")
+		obj, err := FromGoValue(v)
+		if err != nil {
+			return nil, err
 		}
+		store[k] = obj
 	}
 	return &Environment{store: store}, nil
 }
+
+// FromGoValue 将go值转换为对象, 切片和数组转换为数组, map转换为哈希表, 结构体和time.Time
+// 转换为只读的记录(Record), 函数转换为内置函数(参数和返回值自动转换), nil指针等转换为null
+func FromGoValue(v interface{}) (Object, error) {
+	switch v := v.(type) {
+	case nil:
+		return NULL, nil
+	case bool:
+		return Boolean(v), nil
+	case int:
+		return Integer(v), nil
+	case int64:
+		return Integer(v), nil
+	case float32:
+		return Float(v), nil
+	case float64:
+		return Float(v), nil
+	case string:
+		return String(v), nil
+	default:
+		return fromValue(reflect.ValueOf(v))
+	}
+}
+
+// ToGoValue 将对象转换为go原生值, 数组和区间转换为[]interface{}, 键全部为字符串的哈希表转换为
+// map[string]interface{}, 其他哈希表转换为map[interface{}]interface{}, 记录转换为原来的go值,
+// 错误对象作为error返回
 func ToGoValue(obj Object) (interface{}, error) {
 	switch obj := obj.(type) {
+	case Null:
+		return nil, nil
 	case Boolean:
 		return bool(obj), nil
 	case Integer:
 		return int64(obj), nil
+	case Float:
+		return float64(obj), nil
 	case String:
 		return string(obj), nil
+	case *Range:
+		arr, err := obj.Array()
+		if err != nil {
+			return nil, err
+		}
+		return ToGoValue(arr)
+	case *Array:
+		arr := make([]interface{}, 0, len(obj.Elements))
+		for _, e := range obj.Elements {
+			v, err := ToGoValue(e)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case *Hash:
+		m := make(map[interface{}]interface{}, obj.Len())
+		stringKeys := true
+		var err error
+		obj.Range(func(key, value Object) bool {
+			var k, v interface{}
+			if k, err = ToGoValue(key); err != nil {
+				return false
+			}
+			if v, err = ToGoValue(value); err != nil {
+				return false
+			}
+			_, isString := k.(string)
+			stringKeys = stringKeys && isString
+			m[k] = v
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !stringKeys {
+			return m, nil
+		}
+		sm := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			sm[k.(string)] = v
+		}
+		return sm, nil
+	case *Record:
+		if !obj.Value.CanInterface() {
+			return nil, errors.New("invalid type")
+		}
+		return obj.Value.Interface(), nil
+	case *Error:
+		return nil, obj
 	default:
 		return nil, errors.New("invalid type")
 	}
 }
+
 func NewEnviroment() *Environment {
 	return &Environment{store: make(map[string]Object)}
 }
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnviroment()
-	env.outer = outer
-	return env
+	return &Environment{store: make(map[string]Object), outer: outer}
 }
 
 type Environment struct {
@@ -57,3 +134,14 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign 修改已定义变量的值, 变量定义在外层环境时修改外层环境中的变量
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
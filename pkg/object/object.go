@@ -3,9 +3,14 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/alwaifu/monkey/pkg/ast"
+	"github.com/alwaifu/monkey/pkg/lexer"
 )
 
 type ObjectType string
@@ -15,23 +20,30 @@ type Object interface {
 }
 
 // TODO: 调整对象系统 使用golang原生对象 提高求值性能
-// TODO: 添加对浮点数据的支持
 const (
 	// 基础类型
 
 	NULL_OBJ    = "NULL"
 	INTEGER_OBJ = "INTEGER"
+	FLOAT_OBJ   = "FLOAT"
 	BOOLEAN_OBJ = "BOOLEAN"
 	STRING_OBJ  = "STRING"
 
 	// 指针类型
 
 	RETURN_VALUE_OBJ      = "RETURN_VALUE"
+	LOOP_CONTROL_OBJ      = "LOOP_CONTROL"
 	ERROR_OBJ             = "ERROR"
 	FUNCTION_OBJ          = "FUNCTION"
 	BUILTIN_OBJ           = "BUILTIN"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
 	ARRAY_OBJ             = "ARRAY"
+	HASH_OBJ              = "HASH"
+	ITERATOR_OBJ          = "ITERATOR"
+	RANGE_OBJ             = "RANGE"
+	RECORD_OBJ            = "RECORD"
 )
 
 var (
@@ -53,6 +65,40 @@ var _ Object = (Integer)(0)
 
 func (i Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i Integer) Inspect() string  { return fmt.Sprintf("%d", i) }
+func (i Integer) HashKey() HashKey { return HashKey{Type: i.Type(), Value: uint64(i)} }
+
+// Pow 整数的n次方, n必须不小于0, 溢出时与乘法一样回绕
+func (i Integer) Pow(n Integer) Integer {
+	result := Integer(1)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			result *= i
+		}
+		i *= i
+	}
+	return result
+}
+
+type Float float64
+
+var _ Object = (Float)(0)
+
+func (f Float) Type() ObjectType { return FLOAT_OBJ }
+func (f Float) Inspect() string {
+	s := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") { // 整数值也保留小数点, 与整数区分
+		s += ".0"
+	}
+	return s
+}
+
+// HashKey 整数值的浮点数与对应的整数是同一个键(与1 == 1.0一致), {1: "a"}[1.0]为"a"
+func (f Float) HashKey() HashKey {
+	if f == Float(math.Trunc(float64(f))) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return Integer(f).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(float64(f))}
+}
 
 type Boolean bool
 
@@ -60,6 +106,12 @@ var _ Object = (Boolean)(false)
 
 func (b Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b Boolean) Inspect() string  { return fmt.Sprintf("%t", b) }
+func (b Boolean) HashKey() HashKey {
+	if b {
+		return HashKey{Type: b.Type(), Value: 1}
+	}
+	return HashKey{Type: b.Type(), Value: 0}
+}
 
 type String string
 
@@ -67,6 +119,26 @@ var _ Object = (String)("")
 
 func (s String) Type() ObjectType { return STRING_OBJ }
 func (s String) Inspect() string  { return string(s) }
+func (s String) HashKey() HashKey { return HashKey{Type: s.Type(), Str: string(s)} }
+
+// Len 字符串的长度, 按Unicode码点计数
+func (s String) Len() int { return utf8.RuneCountInString(string(s)) }
+
+// At 第i个码点组成的字符串, 负数从末尾计数(-1为最后一个码点), i超出范围时ok为false
+func (s String) At(i int) (String, bool) {
+	if i < 0 {
+		if i += s.Len(); i < 0 {
+			return "", false
+		}
+	}
+	for _, r := range string(s) {
+		if i == 0 {
+			return String(r), true
+		}
+		i--
+	}
+	return "", false
+}
 
 // ReturnValue
 type ReturnValue struct {
@@ -78,16 +150,37 @@ var _ Object = (*ReturnValue)(nil)
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// LoopControl break/continue语句的求值结果, 用于中断外层语句块直到所在的循环
+type LoopControl struct {
+	Break bool // true为break, false为continue
+}
+
+var _ Object = (*LoopControl)(nil)
+
+func (lc *LoopControl) Type() ObjectType { return LOOP_CONTROL_OBJ }
+func (lc *LoopControl) Inspect() string {
+	if lc.Break {
+		return "break"
+	}
+	return "continue"
+}
+
 // Error
 type Error struct {
 	Message string
+	Pos     lexer.Position // 产生错误的源码位置, 零值表示未知
 }
 
 var _ Object = (*Error)(nil)
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
-func (e Error) Error() string     { return e.Message }
+func (e *Error) Inspect() string  { return "ERROR: " + e.Error() }
+func (e Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
 
 // Function for interpreter
 type Function struct {
@@ -113,13 +206,32 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
-// BuiltinFunction
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction 内置函数, c为调用它的执行上下文
+type BuiltinFunction func(c Context, args ...Object) Object
+
+// Context 内置函数的执行上下文, 由执行引擎(解释器或虚拟机)实现
+type Context interface {
+	// Call 调用任意可调用的值, 出错(包括执行被取消)时返回*Error, 内置函数应当原样返回该错误
+	Call(fn Object, args ...Object) Object
+	// Err 执行被取消时返回非nil, 耗时的内置函数应当定期检查
+	Err() error
+}
 
 var _ Object = (*Builtin)(nil)
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Name  string
+	Arity int // 参数个数, 负数表示参数个数可变, 由Fn自行检查
+	Doc   string
+	Fn    BuiltinFunction
+}
+
+// Call 检查参数个数后调用Fn
+func (b *Builtin) Call(c Context, args ...Object) Object {
+	if b.Arity >= 0 && len(args) != b.Arity {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), b.Arity)
+	}
+	return b.Fn(c, args...)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -130,6 +242,13 @@ type CompiledFunction struct {
 	Instructions  []byte
 	NumLocals     int
 	NumParameters int
+	SourceMap     []SourcePos // 按Offset升序排列, 用于定位运行时错误
+}
+
+// SourcePos 从Offset开始(直到下一项)的指令所对应的源码位置
+type SourcePos struct {
+	Offset int
+	Pos    lexer.Position
 }
 
 var _ Object = (*CompiledFunction)(nil)
@@ -137,6 +256,36 @@ var _ Object = (*CompiledFunction)(nil)
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
 func (cf *CompiledFunction) Inspect() string  { return fmt.Sprintf("CompiledFunction[%p]", cf) }
 
+// PosAt 返回偏移量offset处指令对应的源码位置
+func (cf *CompiledFunction) PosAt(offset int) lexer.Position {
+	i := sort.Search(len(cf.SourceMap), func(i int) bool { return cf.SourceMap[i].Offset > offset })
+	if i == 0 {
+		return lexer.Position{}
+	}
+	return cf.SourceMap[i-1].Pos
+}
+
+// Closure for vm, 运行时由CompiledFunction和捕获的自由变量组成
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+var _ Object = (*Closure)(nil)
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string  { return fmt.Sprintf("Closure[%p]", c) }
+
+// Cell for vm, 被闭包捕获的局部变量装箱后由外层函数和闭包共享
+type Cell struct {
+	Value Object
+}
+
+var _ Object = (*Cell)(nil)
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
+
 // Array
 type Array struct {
 	Elements []Object
@@ -156,3 +305,288 @@ func (ao *Array) Inspect() string {
 	out.WriteString("]")
 	return out.String()
 }
+
+// Index 将索引i转换为元素的位置, 负数从末尾计数(-1为最后一个元素), 越界时ok为false
+func (ao *Array) Index(i int) (int, bool) {
+	if i < 0 {
+		i += len(ao.Elements)
+	}
+	return i, i >= 0 && i < len(ao.Elements)
+}
+
+// Slice 切片x[start:end:step], x为数组或字符串(按码点), 返回新的数组或字符串.
+// start, end, step为null时表示省略: step默认为1, 为负数时从后向前取, start和end默认为
+// 对应方向的两端. start和end为负数时从末尾计数, 超出范围时截断到两端(不报错). step为0时返回*Error
+func Slice(x, start, end, step Object) Object {
+	var elements []Object
+	var runes []rune
+	switch x := x.(type) {
+	case *Array:
+		elements = x.Elements
+	case String:
+		runes = []rune(string(x))
+	default:
+		return newError("slice operator not supported: %s", x.Type())
+	}
+	length := max(len(elements), len(runes))
+	bounds := [3]int{}
+	for i, arg := range []Object{start, end, step} {
+		switch arg := arg.(type) {
+		case Null:
+		case Integer:
+			bounds[i] = int(arg)
+		default:
+			return newError("slice indices must be INTEGER, got %s", arg.Type())
+		}
+	}
+	n := 1
+	if step.Type() != NULL_OBJ {
+		if n = bounds[2]; n == 0 {
+			return newError("slice step cannot be zero")
+		}
+		// 步长超过长度时最多取一个元素, 截断以免计算位置时溢出
+		n = min(max(n, -length-1), length+1)
+	}
+	// 按方向确定两端: 正向为[0, length], 反向为[-1, length-1]
+	lower, upper := 0, length
+	if n < 0 {
+		lower, upper = -1, length-1
+	}
+	clamp := func(arg Object, i, omitted int) int {
+		if arg.Type() == NULL_OBJ {
+			return omitted
+		}
+		if i < 0 {
+			i += length
+		}
+		return min(max(i, lower), upper)
+	}
+	from, to := lower, upper
+	if n < 0 {
+		from, to = upper, lower
+	}
+	from, to = clamp(start, bounds[0], from), clamp(end, bounds[1], to)
+	var positions []int
+	for i := from; (n > 0 && i < to) || (n < 0 && i > to); i += n {
+		positions = append(positions, i)
+	}
+	if runes != nil {
+		out := make([]rune, 0, len(positions))
+		for _, i := range positions {
+			out = append(out, runes[i])
+		}
+		return String(out)
+	}
+	out := make([]Object, 0, len(positions))
+	for _, i := range positions {
+		out = append(out, elements[i])
+	}
+	return &Array{Elements: out}
+}
+
+// HashKey 哈希表的键, 字符串直接使用原值作为键以避免哈希碰撞
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+	Str   string
+}
+
+// Hashable 可以作为哈希表键的对象
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+var (
+	_ Hashable = (Integer)(0)
+	_ Hashable = (Float)(0)
+	_ Hashable = (Boolean)(false)
+	_ Hashable = (String)("")
+)
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash
+type Hash struct {
+	Pairs map[HashKey]HashPair
+	keys  []HashKey // 键的插入顺序, 保证遍历和输出结果稳定
+}
+
+var _ Object = (*Hash)(nil)
+
+func NewHash(size int) *Hash {
+	return &Hash{
+		Pairs: make(map[HashKey]HashPair, size),
+		keys:  make([]HashKey, 0, size),
+	}
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for _, k := range h.keys {
+		pair := h.Pairs[k]
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	pair, ok := h.Pairs[key.HashKey()]
+	return pair.Value, ok
+}
+
+// Set 设置key对应的值, 键已存在时(例如1与1.0)保留原来的键对象
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if pair, ok := h.Pairs[hashKey]; ok {
+		h.Pairs[hashKey] = HashPair{Key: pair.Key, Value: value}
+		return
+	}
+	h.keys = append(h.keys, hashKey)
+	h.Pairs[hashKey] = HashPair{Key: key, Value: value}
+}
+func (h *Hash) Len() int { return len(h.keys) }
+
+// Range 按插入顺序遍历键值对, fn返回false时停止遍历
+func (h *Hash) Range(fn func(key, value Object) bool) {
+	for _, k := range h.keys {
+		pair := h.Pairs[k]
+		if !fn(pair.Key, pair.Value) {
+			return
+		}
+	}
+}
+
+// Member 成员访问obj.name: 哈希表按字符串键取值(键不存在时为null), 记录取字段或方法.
+// optional为true时(obj?.name), obj为null或记录没有该成员时返回null. 其他情况返回*Error
+func Member(obj Object, name string, optional bool) Object {
+	switch obj := obj.(type) {
+	case *Hash:
+		if value, ok := obj.Get(String(name)); ok {
+			return value
+		}
+		return NULL
+	case *Record:
+		if value, ok := obj.Get(name); ok {
+			return value
+		}
+		if optional {
+			return NULL
+		}
+		return newError("unknown field %s of %s", name, obj.typeName())
+	case Null:
+		if optional {
+			return NULL
+		}
+	}
+	return newError("member access not supported: %s", obj.Type())
+}
+
+// Range range函数返回的整数区间[Start, Stop), 按需产生元素而不占用数组的内存.
+// for-in直接遍历区间, 需要数组的内置函数(map, filter等)将其转换为数组
+type Range struct {
+	Start, Stop, Step Integer // Step不为0
+}
+
+var _ Object = (*Range)(nil)
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
+// Len 区间中元素的个数, 超过math.MaxInt时返回math.MaxInt
+func (r *Range) Len() int {
+	var span, step uint64 // 按无符号数计算, 避免相减溢出
+	switch {
+	case r.Step > 0 && r.Start < r.Stop:
+		span, step = uint64(r.Stop)-uint64(r.Start), uint64(r.Step)
+	case r.Step < 0 && r.Start > r.Stop:
+		span, step = uint64(r.Start)-uint64(r.Stop), -uint64(r.Step)
+	default:
+		return 0
+	}
+	return int(min((span-1)/step+1, math.MaxInt))
+}
+
+// At 第i个元素, 0 <= i < r.Len()
+func (r *Range) At(i int) Integer {
+	return r.Start + Integer(i)*r.Step
+}
+
+// Array 将区间转换为数组, 元素超过MaxRangeLength个时返回*Error
+func (r *Range) Array() (*Array, *Error) {
+	n := r.Len()
+	if n > MaxRangeLength {
+		return nil, newError("range too large, limit is %d elements", MaxRangeLength)
+	}
+	elements := make([]Object, n)
+	for i := range elements {
+		elements[i] = r.At(i)
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// Iterator for-in循环的迭代器, 依次产生数组的元素, 字符串中的字符, 哈希表的键(按插入顺序)或区间中的整数
+type Iterator struct {
+	obj   Object
+	index int // 数组和哈希表为下标, 字符串为字节偏移
+}
+
+var _ Object = (*Iterator)(nil)
+
+// NewIterator 对象不可迭代时返回nil
+func NewIterator(obj Object) *Iterator {
+	switch obj.(type) {
+	case *Array, String, *Hash, *Range:
+		return &Iterator{obj: obj}
+	default:
+		return nil
+	}
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return fmt.Sprintf("Iterator[%p]", it) }
+
+// Next 返回下一个值, 遍历结束时返回false. 遍历期间追加的元素也会被遍历到
+func (it *Iterator) Next() (Object, bool) {
+	switch obj := it.obj.(type) {
+	case *Array:
+		if it.index >= len(obj.Elements) {
+			return nil, false
+		}
+		it.index++
+		return obj.Elements[it.index-1], true
+	case String:
+		if it.index >= len(obj) {
+			return nil, false
+		}
+		_, size := utf8.DecodeRuneInString(string(obj[it.index:]))
+		it.index += size
+		return obj[it.index-size : it.index], true
+	case *Hash:
+		if it.index >= len(obj.keys) {
+			return nil, false
+		}
+		it.index++
+		return obj.Pairs[obj.keys[it.index-1]].Key, true
+	case *Range:
+		if it.index >= obj.Len() {
+			return nil, false
+		}
+		it.index++
+		return obj.At(it.index - 1), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,440 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record 只读的go结构体(或time.Time), 通过字段名访问字段, 字段在第一次访问时才转换为对象并缓存.
+// 字段名为导出字段的名称, 或者`monkey:"name"`标签指定的名称, 标签为"-"的字段被忽略
+type Record struct {
+	Value reflect.Value // 原始go值, 类型为结构体
+
+	mu     sync.Mutex
+	fields map[string]Object // 已转换的字段
+}
+
+var _ Object = (*Record)(nil)
+
+func (r *Record) Type() ObjectType { return RECORD_OBJ }
+func (r *Record) Inspect() string {
+	if t, ok := r.time(); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	fields := []string{}
+	for _, name := range r.Fields() {
+		value, _ := r.Get(name)
+		fields = append(fields, name+": "+value.Inspect())
+	}
+	return r.typeName() + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// Fields 返回记录的字段名
+func (r *Record) Fields() []string {
+	if _, ok := r.time(); ok {
+		return timeFields
+	}
+	return recordFields(r.Value.Type()).names
+}
+
+// Get 返回字段的值, 没有该字段时返回同名的方法(作为内置函数), 都不存在时ok为false,
+// 字段的值无法转换时返回*Error
+func (r *Record) Get(name string) (value Object, ok bool) {
+	if t, isTime := r.time(); isTime {
+		if value, ok := timeField(t, name); ok {
+			return value, true
+		}
+		return r.method(name)
+	}
+	index, ok := recordFields(r.Value.Type()).index[name]
+	if !ok {
+		return r.method(name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if obj, ok := r.fields[name]; ok {
+		return obj, true
+	}
+	field, err := r.Value.FieldByIndexErr(index)
+	if err != nil {
+		return NULL, true // 字段所在的嵌入指针为nil
+	}
+	obj, err := fromValue(field)
+	if err != nil {
+		return newError("field %s: %s", name, err), true
+	}
+	if r.fields == nil {
+		r.fields = make(map[string]Object)
+	}
+	r.fields[name] = obj
+	return obj, true
+}
+
+// Index 实现索引运算record["name"], 键不是字符串或者字段不存在时返回*Error
+func (r *Record) Index(key Object) Object {
+	name, ok := key.(String)
+	if !ok {
+		return newError("unusable as record field: %s", key.Type())
+	}
+	value, ok := r.Get(string(name))
+	if !ok {
+		return newError("unknown field %s of %s", name, r.typeName())
+	}
+	return value
+}
+
+// method 返回导出的方法, 原始值可寻址时(由指针转换而来)包括指针接收者的方法
+func (r *Record) method(name string) (Object, bool) {
+	if !r.Value.CanInterface() {
+		return nil, false
+	}
+	receiver := r.Value
+	if receiver.CanAddr() {
+		receiver = receiver.Addr()
+	}
+	method := receiver.MethodByName(name)
+	if !method.IsValid() {
+		return nil, false
+	}
+	return funcBuiltin(r.typeName()+"."+name, method), true
+}
+func (r *Record) typeName() string {
+	if name := r.Value.Type().Name(); name != "" {
+		return name
+	}
+	return RECORD_OBJ
+}
+func (r *Record) time() (time.Time, bool) {
+	if !r.Value.CanInterface() {
+		return time.Time{}, false // 通过未导出的嵌入字段访问到的值
+	}
+	t, ok := r.Value.Interface().(time.Time)
+	return t, ok
+}
+
+// timeFields time.Time作为记录时的字段
+var timeFields = []string{"unix", "unix_milli", "year", "month", "day", "hour", "minute", "second", "weekday"}
+
+func timeField(t time.Time, name string) (Object, bool) {
+	switch name {
+	case "unix":
+		return Integer(t.Unix()), true
+	case "unix_milli":
+		return Integer(t.UnixMilli()), true
+	case "year":
+		return Integer(t.Year()), true
+	case "month":
+		return Integer(t.Month()), true
+	case "day":
+		return Integer(t.Day()), true
+	case "hour":
+		return Integer(t.Hour()), true
+	case "minute":
+		return Integer(t.Minute()), true
+	case "second":
+		return Integer(t.Second()), true
+	case "weekday":
+		return String(t.Weekday().String()), true
+	default:
+		return nil, false
+	}
+}
+
+// structFields 结构体类型的字段名及其在reflect.Value.FieldByIndex中的索引
+type structFields struct {
+	names []string
+	index map[string][]int
+}
+
+var structFieldsCache sync.Map // reflect.Type -> *structFields
+
+func recordFields(t reflect.Type) *structFields {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.(*structFields)
+	}
+	fields := &structFields{index: make(map[string][]int)}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous && f.Type.Kind() == reflect.Struct {
+			continue // 嵌入结构体的字段被提升到外层
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		if _, ok := fields.index[name]; ok {
+			continue // 外层的字段优先
+		}
+		fields.names = append(fields.names, name)
+		fields.index[name] = f.Index
+	}
+	cached, _ := structFieldsCache.LoadOrStore(t, fields)
+	return cached.(*structFields)
+}
+
+// fromValue 通过反射将go值转换为对象
+func fromValue(v reflect.Value) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+	if v.CanInterface() && v.Kind() != reflect.Interface {
+		if obj, ok := v.Interface().(Object); ok {
+			return obj, nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return Boolean(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Integer(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows %s", v.Uint(), INTEGER_OBJ)
+		}
+		return Integer(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return Float(v.Float()), nil
+	case reflect.String:
+		return String(v.String()), nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			el, err := fromValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		hash := NewHash(v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromValue(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", iter.Key().Type())
+			}
+			value, err := fromValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(hashable, value)
+		}
+		return hash, nil
+	case reflect.Struct:
+		return &Record{Value: v}, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if !v.CanInterface() {
+			return nil, fmt.Errorf("unsupported type %s", v.Type())
+		}
+		name := v.Type().String()
+		if f := runtime.FuncForPC(v.Pointer()); f != nil {
+			name = f.Name()
+		}
+		return funcBuiltin(name, v), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+// funcBuiltin 将go函数包装为名为name的内置函数, 参数按函数签名转换.
+// 函数的最后一个返回值为error且不为nil时返回*Error, 函数panic时返回包含name的*Error, 其余返回值:
+// 没有时返回null, 一个时返回其对象, 多个时返回由它们组成的数组
+func funcBuiltin(name string, fn reflect.Value) *Builtin {
+	t := fn.Type()
+	arity := t.NumIn()
+	if t.IsVariadic() {
+		arity = -1
+	}
+	return &Builtin{Name: name, Arity: arity, Fn: func(_ Context, args ...Object) Object {
+		if t.IsVariadic() && len(args) < t.NumIn()-1 {
+			return newError("wrong number of arguments. got=%d, want=%d+", len(args), t.NumIn()-1)
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var paramType reflect.Type
+			if t.IsVariadic() && i >= t.NumIn()-1 {
+				paramType = t.In(t.NumIn() - 1).Elem()
+			} else {
+				paramType = t.In(i)
+			}
+			v, err := toValue(arg, paramType)
+			if err != nil {
+				return newError("argument %d: %s", i+1, err)
+			}
+			in[i] = v
+		}
+		out, err := callFunc(name, fn, in)
+		if err != nil {
+			return newError("%s", err)
+		}
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1]; !err.IsNil() {
+				return newError("%s", err.Interface().(error))
+			}
+			out = out[:n-1]
+		}
+		results := make([]Object, len(out))
+		for i, v := range out {
+			obj, err := fromValue(v)
+			if err != nil {
+				return newError("result %d: %s", i+1, err)
+			}
+			results[i] = obj
+		}
+		switch len(results) {
+		case 0:
+			return NULL
+		case 1:
+			return results[0]
+		default:
+			return &Array{Elements: results}
+		}
+	}}
+}
+
+// callFunc 调用go函数, 将panic转换为错误
+func callFunc(name string, fn reflect.Value, in []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s panicked: %v", name, r)
+		}
+	}()
+	return fn.Call(in), nil
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// toValue 将对象转换为类型t的go值
+func toValue(obj Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		v, err := ToGoValue(obj)
+		if err != nil || v == nil {
+			return reflect.Zero(t), err
+		}
+		return reflect.ValueOf(v), nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+	fail := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+	}
+	v := reflect.New(t).Elem()
+	switch obj := obj.(type) {
+	case Null:
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			return v, nil
+		}
+	case Boolean:
+		if t.Kind() == reflect.Bool {
+			v.SetBool(bool(obj))
+			return v, nil
+		}
+	case Integer:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(int64(obj)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", obj, t)
+			}
+			v.SetInt(int64(obj))
+			return v, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if obj < 0 || v.OverflowUint(uint64(obj)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", obj, t)
+			}
+			v.SetUint(uint64(obj))
+			return v, nil
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(obj))
+			return v, nil
+		}
+	case Float:
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+			v.SetFloat(float64(obj))
+			return v, nil
+		}
+	case String:
+		if t.Kind() == reflect.String {
+			v.SetString(string(obj))
+			return v, nil
+		}
+	case *Record:
+		if obj.Value.Type().AssignableTo(t) {
+			v.Set(obj.Value)
+			return v, nil
+		}
+		if t.Kind() == reflect.Pointer && obj.Value.Type().AssignableTo(t.Elem()) {
+			v.Set(reflect.New(t.Elem()))
+			v.Elem().Set(obj.Value)
+			return v, nil
+		}
+	case *Array:
+		switch t.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(t, len(obj.Elements), len(obj.Elements)))
+		case reflect.Array:
+			if t.Len() != len(obj.Elements) {
+				return fail()
+			}
+		default:
+			return fail()
+		}
+		for i, el := range obj.Elements {
+			elem, err := toValue(el, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.Index(i).Set(elem)
+		}
+		return v, nil
+	case *Hash:
+		if t.Kind() != reflect.Map {
+			return fail()
+		}
+		v.Set(reflect.MakeMapWithSize(t, obj.Len()))
+		var err error
+		obj.Range(func(key, value Object) bool {
+			var k, e reflect.Value
+			if k, err = toValue(key, t.Key()); err != nil {
+				return false
+			}
+			if e, err = toValue(value, t.Elem()); err != nil {
+				return false
+			}
+			v.SetMapIndex(k, e)
+			return true
+		})
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return v, nil
+	}
+	return fail()
+}
@@ -0,0 +1,35 @@
+package object
+
+import (
+	"regexp"
+	"sync"
+)
+
+// MaxRegexpCache 缓存的已编译正则表达式数量上限, 超出后清空重新缓存
+const MaxRegexpCache = 1024
+
+// regexpCache 缓存matches等内置函数编译的正则表达式, 避免规则每次执行都重新编译
+var regexpCache = struct {
+	sync.RWMutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+func compileRegexp(expr string) (*regexp.Regexp, *Error) {
+	regexpCache.RLock()
+	re, ok := regexpCache.m[expr]
+	regexpCache.RUnlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, newError("invalid regular expression %q: %s", expr, err)
+	}
+	regexpCache.Lock()
+	if len(regexpCache.m) >= MaxRegexpCache {
+		clear(regexpCache.m)
+	}
+	regexpCache.m[expr] = re
+	regexpCache.Unlock()
+	return re, nil
+}
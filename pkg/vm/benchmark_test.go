@@ -18,12 +18,11 @@ func BenchmarkVM(b *testing.B) {
 	for k := range env {
 		symbolTable.Define(k)
 	}
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	builtins := object.StandardBuiltins()
+	symbolTable.DefineBuiltins(builtins)
 	constants := []object.Object{}
 	globals := make([]object.Object, GlobalSize)
-	compiler := NewCompiler(symbolTable, constants)
+	compiler := NewCompiler(symbolTable, constants, builtins)
 	compiler.Compile(program)
 	machine := NewVM(compiler, globals)
 	b.ResetTimer()
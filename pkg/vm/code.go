@@ -1,6 +1,9 @@
 package vm
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"fmt"
+)
 
 type Instructions []byte
 
@@ -15,15 +18,17 @@ const (
 	OpDiv
 	OpTrue
 	OpFalse
-	OpAnd
-	OpOr
 	OpEqual
 	OpNotEqual
 	OpGt
+	OpGe
+	OpLt
+	OpLe
 	OpMinus
 	OpBang
 	OpJump
 	OpJumpNotTruthy
+	OpJumpTruthy
 	OpNull
 	OpGetGlobal
 	OpSetGlobal
@@ -39,6 +44,32 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpIter
+	OpIterNext
+	OpSetFree
+	OpSetIndex
+	OpMatchLiteral
+	OpMatchArray
+	OpMatchHash
+	OpJumpTable
+	OpToString
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpBitNot
+	OpMember
+	OpJumpNotNull
+	OpSlice
+	OpLoopEnter
+	OpLoopUnwind
+	OpLoopExit
+	OpCaptureLocal
+	OpCaptureFree
+	OpJumpNull
 )
 
 type Definition struct {
@@ -55,20 +86,22 @@ var definitions = map[Opcode]Definition{
 	OpDiv:            {"OpDiv", []int{}},
 	OpTrue:           {"OpTrue", []int{}},
 	OpFalse:          {"OpFalse", []int{}},
-	OpAnd:            {"OpAnd", []int{}},
-	OpOr:             {"OpOr", []int{}},
 	OpEqual:          {"OpEqual", []int{}},
 	OpNotEqual:       {"OpNotEqual", []int{}},
 	OpGt:             {"OpGt", []int{}},
+	OpGe:             {"OpGe", []int{}},
+	OpLt:             {"OpLt", []int{}},
+	OpLe:             {"OpLe", []int{}},
 	OpMinus:          {"OpMinus", []int{}},
 	OpBang:           {"OpBang", []int{}},
 	OpJump:           {"OpJump", []int{2}},
 	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJumpTruthy:     {"OpJumpTruthy", []int{2}},
 	OpNull:           {"OpNull", []int{}},
 	OpGetGlobal:      {"OpGetGlobal", []int{2}},
 	OpSetGlobal:      {"OpSetGlobal", []int{2}},
 	OpArray:          {"OpArray", []int{2}},
-	OpHash:           {"OpHash", []int{}},
+	OpHash:           {"OpHash", []int{2}}, // number of keys and values
 	OpIndex:          {"OpIndex", []int{}},
 	OpCall:           {"OpCall", []int{1}},
 	OpReturnValue:    {"OpReturnValue", []int{}},
@@ -76,9 +109,35 @@ var definitions = map[Opcode]Definition{
 	OpGetLocal:       {"OpGetLocal", []int{1}},
 	OpSetLocal:       {"OpSetLocal", []int{1}},
 	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
-	OpClosure:        {"OpClosure", []int{}},
-	OpGetFree:        {"OpGetFree", []int{}},
+	OpClosure:        {"OpClosure", []int{2, 1}}, // constant index of function, number of free variables
+	OpGetFree:        {"OpGetFree", []int{1}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpIter:           {"OpIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2}}, // position to jump to when iteration is done
+	OpSetFree:        {"OpSetFree", []int{1}},
+	OpSetIndex:       {"OpSetIndex", []int{1}},     // arithmetic opcode applied to old value before set, 0 for plain set
+	OpMatchLiteral:   {"OpMatchLiteral", []int{2}}, // constant index of literal
+	OpMatchArray:     {"OpMatchArray", []int{2}},   // length of array
+	OpMatchHash:      {"OpMatchHash", []int{2}},    // constant index of array of keys
+	OpJumpTable:      {"OpJumpTable", []int{2, 2}}, // constant index of hash from literal to position, default position
+	OpToString:       {"OpToString", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpPow:            {"OpPow", []int{}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpShl:            {"OpShl", []int{}},
+	OpShr:            {"OpShr", []int{}},
+	OpBitNot:         {"OpBitNot", []int{}},
+	OpMember:         {"OpMember", []int{2, 1}},   // constant index of member name, 1 for optional access
+	OpJumpNotNull:    {"OpJumpNotNull", []int{2}}, // keeps the value and jumps if it is not null, otherwise pops it
+	OpSlice:          {"OpSlice", []int{}},        // pops start, end and step (null when omitted)
+	OpLoopEnter:      {"OpLoopEnter", []int{}},    // saves stack pointer at loop entry
+	OpLoopUnwind:     {"OpLoopUnwind", []int{}},   // restores stack pointer of innermost loop before break/continue
+	OpLoopExit:       {"OpLoopExit", []int{}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{1}}, // boxes local variable into a cell shared with closure
+	OpCaptureFree:    {"OpCaptureFree", []int{1}},  // pushes cell of free variable without dereferencing
+	OpJumpNull:       {"OpJumpNull", []int{2}},     // keeps the value and jumps if it is null
 }
 
 func MakeInstruction(op Opcode, operands ...int) []byte {
@@ -110,3 +169,10 @@ func MakeInstruction(op Opcode, operands ...int) []byte {
 	}
 	return instructions
 }
+
+func (op Opcode) String() string {
+	if def, ok := definitions[op]; ok {
+		return def.Name
+	}
+	return fmt.Sprintf("Opcode(%d)", op)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/alwaifu/monkey/pkg/ast"
+	"github.com/alwaifu/monkey/pkg/lexer"
 	"github.com/alwaifu/monkey/pkg/object"
 )
 
@@ -11,18 +12,39 @@ type CompilationScope struct {
 	instructions        Instructions
 	lastInsPosition     int // position of last instruction
 	previousInsPosition int // position of previous instruction
+	sourceMap           []object.SourcePos
+	loops               []*loopScope // loops being compiled, innermost last
+}
+
+// loopScope 正在编译的循环, 用于编译break/continue
+type loopScope struct {
+	continuePos int   // continue跳转的目标位置
+	breakJumps  []int // break跳转指令的位置, 循环编译完成后回填
+	iterator    bool  // for-in循环, break前需要弹出栈顶的迭代器
 }
 
 type Compiler struct {
 	Constants []object.Object
 
 	symbolTable *SymbolTable
+	builtins    []*object.Builtin // snapshot of registry when compiler is created
 
 	scopes     []*CompilationScope
 	scopeIndex int
+
+	pos lexer.Position // source position of the node being compiled
+
+	matchDepth int // nesting depth of match expressions, used to name the hidden variable holding match value
+
+	chainLink  bool  // the node being compiled is object part of a member, index, slice or call expression
+	chainJumps []int // positions of OpJumpNull emitted by ?. of current chain
 }
 
-func NewCompiler(s *SymbolTable, constants []object.Object) *Compiler {
+// NewCompiler creates compiler which resolves builtins in registry b, nil means standard builtins.
+// The builtins are copied, later changes of b don't affect the bytecode.
+// If s is nil a new symbol table defining all builtins of b is used,
+// otherwise builtins should have been defined in s by s.DefineBuiltins(b)
+func NewCompiler(s *SymbolTable, constants []object.Object, b *object.Builtins) *Compiler {
 	mainScope := &CompilationScope{
 		instructions:        make(Instructions, 0, 256),
 		lastInsPosition:     0,
@@ -33,10 +55,12 @@ func NewCompiler(s *SymbolTable, constants []object.Object) *Compiler {
 		scopes:      []*CompilationScope{mainScope},
 		scopeIndex:  0,
 	}
+	if b == nil {
+		b = object.StandardBuiltins()
+	}
+	c.builtins = b.List()
 	if s == nil {
-		for i, v := range object.Builtins {
-			c.symbolTable.DefineBuiltin(i, v.Name)
-		}
+		c.symbolTable.DefineBuiltins(b)
 	} else {
 		c.symbolTable = s
 	}
@@ -47,28 +71,45 @@ func NewCompiler(s *SymbolTable, constants []object.Object) *Compiler {
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if pos := node.Pos(); pos.IsValid() {
+		outer := c.pos
+		c.pos = pos
+		defer func() { c.pos = outer }()
+	}
+	chainLink := c.chainLink
+	c.chainLink = false
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			if err := c.Compile(s); err != nil {
+		// 最后一个表达式语句的值留在栈上, 程序结束时由OpReturnValue作为程序的结果
+		last := -1
+		for i, s := range node.Statements {
+			if _, ok := s.(*ast.ExpressionStatement); ok {
+				last = i
+			}
+		}
+		for i, s := range node.Statements {
+			var n ast.Node = s
+			if i == last {
+				n = s.(*ast.ExpressionStatement).Expression
+			}
+			if err := c.Compile(n); err != nil {
 				return err
 			}
 		}
+		if last >= 0 {
+			c.emit(OpReturnValue)
+		}
 	case *ast.ExpressionStatement:
 		if err := c.Compile(node.Expression); err != nil {
 			return err
 		}
 		c.emit(OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			if err := c.Compile(node.Right); err != nil {
-				return err
-			}
-			if err := c.Compile(node.Left); err != nil {
-				return err
-			}
-			c.emit(OpGt)
-			break
+		if node.Operator == "and" || node.Operator == "or" {
+			return c.compileLogical(node)
+		}
+		if node.Operator == "??" {
+			return c.compileNullish(node)
 		}
 		if err := c.Compile(node.Left); err != nil {
 			return err
@@ -85,18 +126,34 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(OpMul)
 		case "/":
 			c.emit(OpDiv)
+		case "%":
+			c.emit(OpMod)
+		case "**":
+			c.emit(OpPow)
+		case "&":
+			c.emit(OpBitAnd)
+		case "|":
+			c.emit(OpBitOr)
+		case "^":
+			c.emit(OpBitXor)
+		case "<<":
+			c.emit(OpShl)
+		case ">>":
+			c.emit(OpShr)
 		case ">":
 			c.emit(OpGt)
+		case ">=":
+			c.emit(OpGe)
+		case "<":
+			c.emit(OpLt)
+		case "<=":
+			c.emit(OpLe)
 		case "==":
 			c.emit(OpEqual)
 		case "!=":
 			c.emit(OpNotEqual)
-		case "and":
-			c.emit(OpAnd)
-		case "or":
-			c.emit(OpOr)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return c.errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.PrefixExpression:
 		if err := c.Compile(node.Right); err != nil {
@@ -107,8 +164,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(OpBang)
 		case "-":
 			c.emit(OpMinus)
+		case "~":
+			c.emit(OpBitNot)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return c.errorf("unknown operator %s", node.Operator)
 		}
 	case *ast.IfExpression:
 		if err := c.Compile(node.Condition); err != nil {
@@ -120,7 +179,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err := c.Compile(node.Consequence); err != nil {
 			return err
 		}
-		c.removeLastPop()
+		c.removeLastPopOrEmitNull()
 		jumpPos := c.emit(OpJump, 9999)
 		afterConsequencePos := len(c.scopes[c.scopeIndex].instructions)
 		c.replaceInstruction(jumpNotTruthyPos, MakeInstruction(OpJumpNotTruthy, afterConsequencePos))
@@ -131,7 +190,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err := c.Compile(node.Alternative); err != nil {
 				return err
 			}
-			c.removeLastPop()
+			c.removeLastPopOrEmitNull()
 		}
 		afterAlternativePos := len(c.scopes[c.scopeIndex].instructions)
 		c.replaceInstruction(jumpPos, MakeInstruction(OpJump, afterAlternativePos))
@@ -144,14 +203,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.IntegerLiteral:
 		constIdx := c.addConstant(object.Integer(node.Value))
 		c.emit(OpConstant, constIdx)
+	case *ast.FloatLiteral:
+		c.emit(OpConstant, c.addConstant(object.Float(node.Value)))
 	case *ast.BooleanLiteral:
 		if node.Value {
 			c.emit(OpTrue)
 		} else {
 			c.emit(OpFalse)
 		}
+	case *ast.NullLiteral:
+		c.emit(OpNull)
 	case *ast.StringLiteral:
 		c.emit(OpConstant, c.addConstant(object.String(node.Value)))
+	case *ast.TemplateLiteral:
+		// "a${x}b" => "a" + str(x) + "b"
+		for i, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+			if _, ok := part.(*ast.StringLiteral); !ok {
+				c.emit(OpToString)
+			}
+			if i > 0 {
+				c.emit(OpAdd)
+			}
+		}
 	case *ast.ArrayLiteral:
 		for _, el := range node.Elements {
 			if err := c.Compile(el); err != nil {
@@ -159,8 +235,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		c.emit(OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		for i, key := range node.Keys {
+			if err := c.Compile(key); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Values[i]); err != nil {
+				return err
+			}
+		}
+		c.emit(OpHash, len(node.Keys)*2)
 	case *ast.FunctionLiteral:
 		c.enterScope()
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
 		}
@@ -168,14 +257,28 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.replaceFunctionLastPopWithReturn()
-		numLocals := len(c.symbolTable.store) // number of local var
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions // number of local var
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
 		instructions := c.leaveScope()
+		// push cells of captured variables onto stack, OpClosure will move them into closure
+		for _, s := range freeSymbols {
+			switch s.Scope {
+			case LocalScope:
+				c.emit(OpCaptureLocal, s.Index)
+			case FreeScope:
+				c.emit(OpCaptureFree, s.Index)
+			default:
+				c.loadSymbol(s)
+			}
+		}
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			SourceMap:     sourceMap,
 		}
-		c.emit(OpConstant, c.addConstant(compiledFn))
+		c.emit(OpClosure, c.addConstant(compiledFn), len(freeSymbols))
 	case *ast.ReturnStatement:
 		if err := c.Compile(node.ReturnValue); err != nil {
 			return err
@@ -183,7 +286,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(OpReturnValue)
 	case *ast.CallExpression:
 		// node.Function can be FunctionLiteral or Identifier, so there is no way to verify arguments at compiler
-		if err := c.Compile(node.Function); err != nil {
+		endChain := c.beginChain(chainLink)
+		if err := c.compileChainObject(node.Function); err != nil {
 			return err
 		}
 		for _, a := range node.Arguments {
@@ -192,43 +296,473 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		c.emit(OpCall, len(node.Arguments))
+		endChain()
 	case *ast.LetStatement:
 		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
-		symbol := c.symbolTable.Define(node.Name.Value)
-		if symbol.Scope == GlobalScope {
-			c.emit(OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(OpSetLocal, symbol.Index)
+		c.storeSymbol(c.symbolTable.Define(node.Name.Value))
+	case *ast.WhileStatement:
+		// OpLoopEnter start: <condition> OpJumpNotTruthy end <body> OpJump start end: OpLoopExit
+		c.emit(OpLoopEnter)
+		startPos := len(c.scopes[c.scopeIndex].instructions)
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exitPos := c.emit(OpJumpNotTruthy, 9999)
+		endPos, err := c.compileLoopBody(node.Body, &loopScope{continuePos: startPos})
+		if err != nil {
+			return err
+		}
+		c.replaceInstruction(exitPos, MakeInstruction(OpJumpNotTruthy, endPos))
+		c.emit(OpLoopExit)
+		c.emit(OpNull) // 与解释器一致, 循环语句的值为null
+		c.emit(OpPop)
+	case *ast.ForStatement:
+		// <iterable> OpIter OpLoopEnter start: OpIterNext end OpSet <var> <body> OpJump start end: OpLoopExit
+		// 迭代器在循环期间留在栈顶, 遍历结束时由OpIterNext弹出
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		c.emit(OpIter)
+		c.emit(OpLoopEnter)
+		startPos := c.emit(OpIterNext, 9999)
+		c.storeSymbol(c.symbolTable.Define(node.Variable.Value))
+		endPos, err := c.compileLoopBody(node.Body, &loopScope{continuePos: startPos, iterator: true})
+		if err != nil {
+			return err
+		}
+		c.replaceInstruction(startPos, MakeInstruction(OpIterNext, endPos))
+		c.emit(OpLoopExit)
+		c.emit(OpNull)
+		c.emit(OpPop)
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return c.errorf("%s outside loop", node.TokenLiteral())
+		}
+		loop := loops[len(loops)-1]
+		// break/continue可能位于表达式中(例如[1, if (c) { break }]), 先丢弃外层表达式已经压栈的值
+		c.emit(OpLoopUnwind)
+		if _, ok := node.(*ast.ContinueStatement); ok {
+			c.emit(OpJump, loop.continuePos)
+			break
+		}
+		if loop.iterator {
+			c.emit(OpPop)
 		}
+		loop.breakJumps = append(loop.breakJumps, c.emit(OpJump, 9999))
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return c.errorf("undefined variable %s", node.Value)
+		}
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		endChain := c.beginChain(chainLink)
+		if err := c.compileChainObject(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(OpIndex)
+		endChain()
+	case *ast.SliceExpression:
+		// <left> <start> <end> <step> OpSlice, 省略的部分为OpNull
+		endChain := c.beginChain(chainLink)
+		if err := c.compileChainObject(node.Left); err != nil {
+			return err
+		}
+		for _, e := range []ast.Expression{node.Start, node.End, node.Step} {
+			if e == nil {
+				c.emit(OpNull)
+			} else if err := c.Compile(e); err != nil {
+				return err
+			}
+		}
+		c.emit(OpSlice)
+		endChain()
+	case *ast.MemberExpression:
+		// <a> OpJumpNull END OpMember b ... END: a?.b遇到null时跳过整个链, 链的值为null
+		endChain := c.beginChain(chainLink)
+		if err := c.compileChainObject(node.Object); err != nil {
+			return err
+		}
+		optional := 0
+		if node.Optional {
+			optional = 1
+			c.chainJumps = append(c.chainJumps, c.emit(OpJumpNull, 9999))
+		}
+		c.emit(OpMember, c.addConstant(object.String(node.Property.Value)), optional)
+		endChain()
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
+	case *ast.MatchExpression:
+		if isJumpTableMatch(node) {
+			return c.compileJumpTable(node)
+		}
+		return c.compileMatch(node)
+	}
+	return nil
+}
+
+// assignOperators 复合赋值运算符对应的运算指令
+var assignOperators = map[string]Opcode{"+=": OpAdd, "-=": OpSub, "*=": OpMul, "/=": OpDiv}
+
+// compileAssign 编译赋值表达式, 表达式的值为赋值后的值:
+//
+//	x = v:     <v> OpSet x OpGet x
+//	x += v:    OpGet x <v> OpAdd OpSet x OpGet x
+//	a[i] += v: <a> <i> <v> OpSetIndex OpAdd
+//	a.b += v:  <a> "b" <v> OpSetIndex OpAdd
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	op, compound := assignOperators[node.Operator]
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return c.errorf("undefined variable %s", target.Value)
+		}
+		if symbol.Scope == BuiltinScope || symbol.Scope == FunctionScope {
+			return c.errorf("cannot assign to %s", target.Value)
+		}
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
 		}
 		switch symbol.Scope {
 		case GlobalScope:
-			c.emit(OpGetGlobal, symbol.Index)
+			c.emit(OpSetGlobal, symbol.Index)
 		case LocalScope:
-			c.emit(OpGetLocal, symbol.Index)
-		case BuiltinScope:
-			c.emit(OpGetBuiltin, symbol.Index)
-		default:
-			return fmt.Errorf("undefined variable %s", node.Value)
+			c.emit(OpSetLocal, symbol.Index)
+		case FreeScope:
+			c.emit(OpSetFree, symbol.Index)
 		}
+		c.loadSymbol(symbol)
 	case *ast.IndexExpression:
-		if err := c.Compile(node.Left); err != nil {
+		if err := c.Compile(target.Left); err != nil {
 			return err
 		}
-		if err := c.Compile(node.Index); err != nil {
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
 			return err
 		}
+		if !compound {
+			op = 0
+		}
+		c.emit(OpSetIndex, int(op))
+	case *ast.MemberExpression:
+		if err := c.Compile(target.Object); err != nil {
+			return err
+		}
+		c.emit(OpConstant, c.addConstant(object.String(target.Property.Value)))
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if !compound {
+			op = 0
+		}
+		c.emit(OpSetIndex, int(op))
+	default:
+		return c.errorf("invalid assignment target %s", node.Target)
+	}
+	return nil
+}
+
+// isJumpTableMatch 所有分支都是没有guard的字面量模式(最后一个分支可以是`_`)时可以编译为跳转表
+func isJumpTableMatch(node *ast.MatchExpression) bool {
+	for i, arm := range node.Arms {
+		if arm.Guard != nil {
+			return false
+		}
+		if ident, ok := arm.Pattern.(*ast.Identifier); ok && ident.Value == "_" && i == len(node.Arms)-1 {
+			continue
+		}
+		// 整数值的浮点数与整数的键相同, 不能放在同一个表中
+		if literal := patternConstant(arm.Pattern); literal == nil || literal.Type() == object.FLOAT_OBJ {
+			return false
+		}
+	}
+	return true
+}
+
+// compileJumpTable 根据值直接跳转到对应的分支:
+//
+//	<value> OpJumpTable table default <body1> OpJump END <body2> OpJump END default: <_ body or OpNull> END:
+func (c *Compiler) compileJumpTable(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	table := object.NewHash(len(node.Arms))
+	tableIndex := c.addConstant(table)
+	tablePos := c.emit(OpJumpTable, tableIndex, 9999)
+	endJumps := []int{}
+	for _, arm := range node.Arms {
+		literal := patternConstant(arm.Pattern)
+		if literal == nil {
+			break // `_`
+		}
+		if _, ok := table.Get(literal); !ok { // 重复的字面量由第一个分支处理
+			table.Set(literal, object.Integer(len(c.scopes[c.scopeIndex].instructions)))
+		}
+		if err := c.Compile(arm.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(OpJump, 9999))
+	}
+	c.replaceInstruction(tablePos, MakeInstruction(OpJumpTable, tableIndex, len(c.scopes[c.scopeIndex].instructions)))
+	if n := len(node.Arms); n > 0 && patternConstant(node.Arms[n-1].Pattern) == nil {
+		if err := c.Compile(node.Arms[n-1].Body); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OpNull)
+	}
+	endPos := len(c.scopes[c.scopeIndex].instructions)
+	for _, pos := range endJumps {
+		c.replaceInstruction(pos, MakeInstruction(OpJump, endPos))
+	}
+	return nil
+}
+
+// compileMatch 依次尝试每个分支, 待匹配的值保存在隐藏变量中:
+//
+//	<value> OpSet v
+//	<pattern tests> OpJumpNotTruthy NEXT <bindings> <guard> OpJumpNotTruthy NEXT <body> OpJump END NEXT:
+//	... OpNull END:
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	c.matchDepth++
+	defer func() { c.matchDepth-- }()
+	// 变量名不是合法的标识符, 不会与用户定义的变量冲突
+	value := c.symbolTable.Define(fmt.Sprintf("match#%d", c.matchDepth))
+	c.storeSymbol(value)
+
+	endJumps := []int{}
+	for _, arm := range node.Arms {
+		var nextJumps []int
+		var bindings []patternBinding
+		c.compilePatternTest(arm.Pattern, value, nil, &nextJumps, &bindings)
+		for _, b := range bindings {
+			c.loadPatternValue(value, b.path)
+			c.storeSymbol(c.symbolTable.Define(b.name))
+		}
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return err
+			}
+			nextJumps = append(nextJumps, c.emit(OpJumpNotTruthy, 9999))
+		}
+		if err := c.Compile(arm.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(OpJump, 9999))
+		nextPos := len(c.scopes[c.scopeIndex].instructions)
+		for _, pos := range nextJumps {
+			c.replaceInstruction(pos, MakeInstruction(OpJumpNotTruthy, nextPos))
+		}
+	}
+	c.emit(OpNull)
+	endPos := len(c.scopes[c.scopeIndex].instructions)
+	for _, pos := range endJumps {
+		c.replaceInstruction(pos, MakeInstruction(OpJump, endPos))
+	}
+	return nil
+}
+
+// patternBinding 模式中绑定的变量, path为从待匹配的值到该变量的索引序列
+type patternBinding struct {
+	name string
+	path []object.Object
+}
+
+// compilePatternTest 编译检查path处的值是否匹配模式的指令, 不匹配时跳转的指令位置保存在nextJumps中.
+// 所有检查都通过后才绑定变量, 避免部分匹配的模式修改变量
+func (c *Compiler) compilePatternTest(pattern ast.Expression, value Symbol, path []object.Object, nextJumps *[]int, bindings *[]patternBinding) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			*bindings = append(*bindings, patternBinding{name: pattern.Value, path: path})
+		}
+	case *ast.ArrayLiteral:
+		c.loadPatternValue(value, path)
+		c.emit(OpMatchArray, len(pattern.Elements))
+		*nextJumps = append(*nextJumps, c.emit(OpJumpNotTruthy, 9999))
+		for i, element := range pattern.Elements {
+			c.compilePatternTest(element, value, appendPath(path, object.Integer(i)), nextJumps, bindings)
+		}
+	case *ast.HashLiteral:
+		keys := make([]object.Object, len(pattern.Keys))
+		for i, key := range pattern.Keys {
+			keys[i] = patternConstant(key)
+		}
+		c.loadPatternValue(value, path)
+		c.emit(OpMatchHash, c.addConstant(&object.Array{Elements: keys}))
+		*nextJumps = append(*nextJumps, c.emit(OpJumpNotTruthy, 9999))
+		for i, v := range pattern.Values {
+			c.compilePatternTest(v, value, appendPath(path, keys[i]), nextJumps, bindings)
+		}
+	default:
+		c.loadPatternValue(value, path)
+		c.emit(OpMatchLiteral, c.addConstant(patternConstant(pattern)))
+		*nextJumps = append(*nextJumps, c.emit(OpJumpNotTruthy, 9999))
+	}
+}
+func (c *Compiler) loadPatternValue(value Symbol, path []object.Object) {
+	c.loadSymbol(value)
+	for _, index := range path {
+		c.emit(OpConstant, c.addConstant(index))
 		c.emit(OpIndex)
 	}
+}
+func appendPath(path []object.Object, index object.Object) []object.Object {
+	return append(path[:len(path):len(path)], index)
+}
+
+// patternConstant 字面量模式的值, 不是字面量时返回nil
+func patternConstant(pattern ast.Expression) object.Hashable {
+	switch pattern := pattern.(type) {
+	case *ast.IntegerLiteral:
+		return object.Integer(pattern.Value)
+	case *ast.FloatLiteral:
+		return object.Float(pattern.Value)
+	case *ast.StringLiteral:
+		return object.String(pattern.Value)
+	case *ast.BooleanLiteral:
+		return object.Boolean(pattern.Value)
+	case *ast.PrefixExpression:
+		switch right := patternConstant(pattern.Right).(type) {
+		case object.Integer:
+			return -right
+		case object.Float:
+			return -right
+		}
+	}
 	return nil
 }
 
+// compileLogical 短路求值and/or, 结果为布尔值.
+// 连续的同一运算符(a or b or c)展开为一组条件跳转, 任一操作数决定结果时直接跳到结尾:
+//
+//	a and b: <a> OpJumpNotTruthy F <b> OpJumpNotTruthy F OpTrue OpJump END F: OpFalse END:
+//	a or b:  <a> OpJumpTruthy T <b> OpJumpTruthy T OpFalse OpJump END T: OpTrue END:
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	jumpOp, fallthroughOp, decidedOp := OpJumpNotTruthy, OpTrue, OpFalse
+	if node.Operator == "or" {
+		jumpOp, fallthroughOp, decidedOp = OpJumpTruthy, OpFalse, OpTrue
+	}
+	var jumpPositions []int
+	for _, operand := range logicalOperands(node, node.Operator, nil) {
+		if err := c.Compile(operand); err != nil {
+			return err
+		}
+		jumpPositions = append(jumpPositions, c.emit(jumpOp, 9999))
+	}
+	c.emit(fallthroughOp)
+	jumpPos := c.emit(OpJump, 9999)
+	decidedPos := c.emit(decidedOp)
+	for _, pos := range jumpPositions {
+		c.replaceInstruction(pos, MakeInstruction(jumpOp, decidedPos))
+	}
+	c.replaceInstruction(jumpPos, MakeInstruction(OpJump, len(c.scopes[c.scopeIndex].instructions)))
+	return nil
+}
+
+// beginChain 开始编译成员访问, 索引, 切片或调用表达式组成的链(a?.b.c(d)[e]).
+// 节点是外层表达式的对象部分(chainLink)时属于外层的链, 否则开始新的链.
+// 返回的函数结束链, 把链中OpJumpNull的跳转位置设为链的末尾
+func (c *Compiler) beginChain(chainLink bool) (endChain func()) {
+	if chainLink {
+		return func() {}
+	}
+	outer := c.chainJumps
+	c.chainJumps = nil
+	return func() {
+		endPos := len(c.scopes[c.scopeIndex].instructions)
+		for _, pos := range c.chainJumps {
+			c.replaceInstruction(pos, MakeInstruction(OpJumpNull, endPos))
+		}
+		c.chainJumps = outer
+	}
+}
+
+// compileChainObject 编译链中表达式的对象部分(a.b中的a, a(b)中的a), 它与外层表达式属于同一个链
+func (c *Compiler) compileChainObject(node ast.Expression) error {
+	c.chainLink = true
+	return c.Compile(node)
+}
+
+// compileNullish 编译a ?? b, a不为null时结果为a, 否则为b:
+//
+//	<a> OpJumpNotNull END <b> END:
+func (c *Compiler) compileNullish(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	jumpPos := c.emit(OpJumpNotNull, 9999)
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.replaceInstruction(jumpPos, MakeInstruction(OpJumpNotNull, len(c.scopes[c.scopeIndex].instructions)))
+	return nil
+}
+
+// logicalOperands 按求值顺序收集连续使用operator连接的操作数
+func logicalOperands(exp ast.Expression, operator string, operands []ast.Expression) []ast.Expression {
+	if infix, ok := exp.(*ast.InfixExpression); ok && infix.Operator == operator {
+		operands = logicalOperands(infix.Left, operator, operands)
+		return logicalOperands(infix.Right, operator, operands)
+	}
+	return append(operands, exp)
+}
+
+// compileLoopBody 编译循环体和跳回循环开始的指令, 并将break跳转到循环结束的位置endPos
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, loop *loopScope) (endPos int, err error) {
+	scope := c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, loop)
+	err = c.Compile(body)
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	if err != nil {
+		return 0, err
+	}
+	c.emit(OpJump, loop.continuePos)
+	endPos = len(scope.instructions)
+	for _, pos := range loop.breakJumps {
+		c.replaceInstruction(pos, MakeInstruction(OpJump, endPos))
+	}
+	return endPos, nil
+}
+
+// Bytecode is result of compilation, it is read only after compile so it can be shared by many vm
+type Bytecode struct {
+	Main      *object.CompiledFunction // top level instructions
+	Constants []object.Object
+	Builtins  []*object.Builtin // builtins indexed by OpGetBuiltin
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	scope := c.scopes[c.scopeIndex]
+	return &Bytecode{
+		Main:      &object.CompiledFunction{Instructions: scope.instructions, SourceMap: scope.sourceMap},
+		Constants: c.Constants,
+		Builtins:  c.builtins,
+	}
+}
+
+// errorf returns compile error prefixed with source position of current node
+func (c *Compiler) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s", c.pos, fmt.Sprintf(format, a...))
+}
 func (c *Compiler) addConstant(obj object.Object) int {
 	c.Constants = append(c.Constants, obj)
 	return len(c.Constants) - 1
@@ -237,23 +771,63 @@ func (c *Compiler) emit(op Opcode, operands ...int) int {
 	scope := c.scopes[c.scopeIndex]
 	ins := MakeInstruction(op, operands...)
 	pos := len(scope.instructions)
+	if n := len(scope.sourceMap); n == 0 || scope.sourceMap[n-1].Pos != c.pos {
+		scope.sourceMap = append(scope.sourceMap, object.SourcePos{Offset: pos, Pos: c.pos})
+	}
 	scope.instructions = append(scope.instructions, ins...)
 	scope.previousInsPosition = scope.lastInsPosition
 	scope.lastInsPosition = pos
 	return pos // position of this instruction
 }
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(OpSetGlobal, s.Index)
+	} else {
+		c.emit(OpSetLocal, s.Index)
+	}
+}
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(OpCurrentClosure)
+	}
+}
+func (c *Compiler) lastInstructionIs(op Opcode) bool {
+	scope := c.scopes[c.scopeIndex]
+	return len(scope.instructions) > 0 && Opcode(scope.instructions[scope.lastInsPosition]) == op
+}
+
+// removeLastPopOrEmitNull 语句块的值为最后一条表达式语句的值, 否则(以let, 循环等语句结尾或者为空)为null
+func (c *Compiler) removeLastPopOrEmitNull() {
+	if c.lastInstructionIs(OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(OpNull)
+	}
+}
 func (c *Compiler) removeLastPop() {
 	scope := c.scopes[c.scopeIndex]
-	if scope.instructions[scope.lastInsPosition] == byte(OpPop) {
+	if c.lastInstructionIs(OpPop) {
 		scope.instructions = scope.instructions[:scope.lastInsPosition]
 		scope.lastInsPosition = scope.previousInsPosition
+		for n := len(scope.sourceMap); n > 0 && scope.sourceMap[n-1].Offset >= len(scope.instructions); n-- {
+			scope.sourceMap = scope.sourceMap[:n-1]
+		}
 	}
 }
 func (c *Compiler) replaceFunctionLastPopWithReturn() {
 	scope := c.scopes[c.scopeIndex]
-	if len(scope.instructions) > 0 {
+	if c.lastInstructionIs(OpPop) {
 		c.replaceInstruction(scope.lastInsPosition, MakeInstruction(OpReturnValue))
-	} else {
+	} else if !c.lastInstructionIs(OpReturnValue) {
 		c.emit(OpReturn)
 	}
 }
@@ -18,7 +18,7 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpAdd),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -28,7 +28,7 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpPop),
 				MakeInstruction(OpConstant, 1),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -38,7 +38,7 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpSub),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -48,7 +48,7 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpMul),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -58,7 +58,7 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpDiv),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -67,7 +67,7 @@ func TestCompileIntegerArithmetic(t *testing.T) {
 			expectedInstructions: []Instructions{
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpMinus),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
@@ -81,7 +81,7 @@ func TestComplileBooleanExpressions(t *testing.T) {
 			expectedConstants: []interface{}{},
 			expectedInstructions: []Instructions{
 				MakeInstruction(OpTrue),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -89,7 +89,7 @@ func TestComplileBooleanExpressions(t *testing.T) {
 			expectedConstants: []interface{}{},
 			expectedInstructions: []Instructions{
 				MakeInstruction(OpFalse),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -99,17 +99,37 @@ func TestComplileBooleanExpressions(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpGt),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []Instructions{
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
-				MakeInstruction(OpGt),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpLt),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpGe),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpLe),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -119,7 +139,7 @@ func TestComplileBooleanExpressions(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpEqual),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -129,7 +149,7 @@ func TestComplileBooleanExpressions(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpNotEqual),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -139,7 +159,7 @@ func TestComplileBooleanExpressions(t *testing.T) {
 				MakeInstruction(OpTrue),
 				MakeInstruction(OpFalse),
 				MakeInstruction(OpEqual),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -149,7 +169,7 @@ func TestComplileBooleanExpressions(t *testing.T) {
 				MakeInstruction(OpTrue),
 				MakeInstruction(OpFalse),
 				MakeInstruction(OpNotEqual),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -158,7 +178,7 @@ func TestComplileBooleanExpressions(t *testing.T) {
 			expectedInstructions: []Instructions{
 				MakeInstruction(OpTrue),
 				MakeInstruction(OpBang),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
@@ -188,7 +208,7 @@ func TestCompileConditionals(t *testing.T) {
 				// 0012
 				MakeInstruction(OpConstant, 1),
 				// 0015
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -212,13 +232,279 @@ func TestCompileConditionals(t *testing.T) {
 				// 0014
 				MakeInstruction(OpConstant, 2),
 				// 0017
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
 
 	runCompilerTests(t, tests)
 }
+func TestCompileLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 and 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpConstant, 0),
+				// 0003
+				MakeInstruction(OpJumpNotTruthy, 16),
+				// 0006
+				MakeInstruction(OpConstant, 1),
+				// 0009
+				MakeInstruction(OpJumpNotTruthy, 16),
+				// 0012
+				MakeInstruction(OpTrue),
+				// 0013
+				MakeInstruction(OpJump, 17),
+				// 0016
+				MakeInstruction(OpFalse),
+				// 0017
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "1 or 2 or 3",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpConstant, 0),
+				// 0003
+				MakeInstruction(OpJumpTruthy, 22),
+				// 0006
+				MakeInstruction(OpConstant, 1),
+				// 0009
+				MakeInstruction(OpJumpTruthy, 22),
+				// 0012
+				MakeInstruction(OpConstant, 2),
+				// 0015
+				MakeInstruction(OpJumpTruthy, 22),
+				// 0018
+				MakeInstruction(OpFalse),
+				// 0019
+				MakeInstruction(OpJump, 23),
+				// 0022
+				MakeInstruction(OpTrue),
+				// 0023
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+func TestCompileLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 1; continue }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpLoopEnter),
+				// 0001
+				MakeInstruction(OpTrue),
+				// 0002
+				MakeInstruction(OpJumpNotTruthy, 16),
+				// 0005
+				MakeInstruction(OpConstant, 0),
+				// 0008
+				MakeInstruction(OpPop),
+				// 0009
+				MakeInstruction(OpLoopUnwind),
+				// 0010
+				MakeInstruction(OpJump, 1),
+				// 0013
+				MakeInstruction(OpJump, 1),
+				// 0016
+				MakeInstruction(OpLoopExit),
+				// 0017
+				MakeInstruction(OpNull),
+				// 0018
+				MakeInstruction(OpPop),
+			},
+		},
+		{
+			input:             "for (x in [1]) { break }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpConstant, 0),
+				// 0003
+				MakeInstruction(OpArray, 1),
+				// 0006
+				MakeInstruction(OpIter),
+				// 0007
+				MakeInstruction(OpLoopEnter),
+				// 0008
+				MakeInstruction(OpIterNext, 22),
+				// 0011
+				MakeInstruction(OpSetGlobal, 0),
+				// 0014
+				MakeInstruction(OpLoopUnwind),
+				// 0015
+				MakeInstruction(OpPop),
+				// 0016
+				MakeInstruction(OpJump, 22),
+				// 0019
+				MakeInstruction(OpJump, 8),
+				// 0022
+				MakeInstruction(OpLoopExit),
+				// 0023
+				MakeInstruction(OpNull),
+				// 0024
+				MakeInstruction(OpPop),
+			},
+		},
+		{
+			input: "fn() { while (false) {} }",
+			expectedConstants: []interface{}{
+				[]Instructions{
+					MakeInstruction(OpLoopEnter),
+					MakeInstruction(OpFalse),
+					MakeInstruction(OpJumpNotTruthy, 8),
+					MakeInstruction(OpJump, 1),
+					MakeInstruction(OpLoopExit),
+					MakeInstruction(OpNull),
+					MakeInstruction(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpClosure, 0, 0),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+func TestCompileAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x += 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpConstant, 0),
+				// 0003
+				MakeInstruction(OpSetGlobal, 0),
+				// 0006
+				MakeInstruction(OpGetGlobal, 0),
+				// 0009
+				MakeInstruction(OpConstant, 1),
+				// 0012
+				MakeInstruction(OpAdd),
+				// 0013
+				MakeInstruction(OpSetGlobal, 0),
+				// 0016
+				MakeInstruction(OpGetGlobal, 0),
+				// 0019
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "let a = [1]; a[0] *= 2",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpArray, 1),
+				MakeInstruction(OpSetGlobal, 0),
+				MakeInstruction(OpGetGlobal, 0),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpConstant, 2),
+				MakeInstruction(OpSetIndex, int(OpMul)),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input: "fn(a) { fn() { a = 1 } }",
+			expectedConstants: []interface{}{
+				1,
+				[]Instructions{
+					MakeInstruction(OpConstant, 0),
+					MakeInstruction(OpSetFree, 0),
+					MakeInstruction(OpGetFree, 0),
+					MakeInstruction(OpReturnValue),
+				},
+				[]Instructions{
+					MakeInstruction(OpCaptureLocal, 0),
+					MakeInstruction(OpClosure, 1, 1),
+					MakeInstruction(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpClosure, 2, 0),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+func TestCompileMatch(t *testing.T) {
+	table := object.NewHash(2)
+	table.Set(object.Integer(1), object.Integer(8))
+	table.Set(object.String("a"), object.Integer(14))
+	tests := []compilerTestCase{
+		{
+			input:             `match (5) { 1 => 10, "a" => 20, _ => 30 }`,
+			expectedConstants: []interface{}{5, table, 10, 20, 30},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpConstant, 0),
+				// 0003
+				MakeInstruction(OpJumpTable, 1, 20),
+				// 0008
+				MakeInstruction(OpConstant, 2),
+				// 0011
+				MakeInstruction(OpJump, 23),
+				// 0014
+				MakeInstruction(OpConstant, 3),
+				// 0017
+				MakeInstruction(OpJump, 23),
+				// 0020
+				MakeInstruction(OpConstant, 4),
+				// 0023
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "match ([1]) { [a] if a => a }",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpConstant, 0),
+				// 0003
+				MakeInstruction(OpArray, 1),
+				// 0006
+				MakeInstruction(OpSetGlobal, 0),
+				// 0009
+				MakeInstruction(OpGetGlobal, 0),
+				// 0012
+				MakeInstruction(OpMatchArray, 1),
+				// 0015
+				MakeInstruction(OpJumpNotTruthy, 40),
+				// 0018
+				MakeInstruction(OpGetGlobal, 0),
+				// 0021
+				MakeInstruction(OpConstant, 1),
+				// 0024
+				MakeInstruction(OpIndex),
+				// 0025
+				MakeInstruction(OpSetGlobal, 1),
+				// 0028
+				MakeInstruction(OpGetGlobal, 1),
+				// 0031
+				MakeInstruction(OpJumpNotTruthy, 40),
+				// 0034
+				MakeInstruction(OpGetGlobal, 1),
+				// 0037
+				MakeInstruction(OpJump, 41),
+				// 0040
+				MakeInstruction(OpNull),
+				// 0041
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
 func TestCompileGlobalLetStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -244,7 +530,7 @@ func TestCompileGlobalLetStatements(t *testing.T) {
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpSetGlobal, 0),
 				MakeInstruction(OpGetGlobal, 0),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -260,7 +546,7 @@ func TestCompileGlobalLetStatements(t *testing.T) {
 				MakeInstruction(OpGetGlobal, 0),
 				MakeInstruction(OpSetGlobal, 1),
 				MakeInstruction(OpGetGlobal, 1),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
@@ -273,7 +559,7 @@ func TestCompileArrayLiterals(t *testing.T) {
 			expectedConstants: []interface{}{},
 			expectedInstructions: []Instructions{
 				MakeInstruction(OpArray, 0),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -284,7 +570,7 @@ func TestCompileArrayLiterals(t *testing.T) {
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpConstant, 2),
 				MakeInstruction(OpArray, 3),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -301,7 +587,50 @@ func TestCompileArrayLiterals(t *testing.T) {
 				MakeInstruction(OpConstant, 5),
 				MakeInstruction(OpMul),
 				MakeInstruction(OpArray, 3),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+func TestCompileHashLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{}",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpHash, 0),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "{1: 2, 3: 4, 5: 6}",
+			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpConstant, 2),
+				MakeInstruction(OpConstant, 3),
+				MakeInstruction(OpConstant, 4),
+				MakeInstruction(OpConstant, 5),
+				MakeInstruction(OpHash, 6),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "{1: 2 + 3, 4: 5 * 6}",
+			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpConstant, 2),
+				MakeInstruction(OpAdd),
+				MakeInstruction(OpConstant, 3),
+				MakeInstruction(OpConstant, 4),
+				MakeInstruction(OpConstant, 5),
+				MakeInstruction(OpMul),
+				MakeInstruction(OpHash, 4),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
@@ -321,23 +650,124 @@ func TestCompileIndexExpressions(t *testing.T) {
 				MakeInstruction(OpConstant, 4),
 				MakeInstruction(OpAdd),
 				MakeInstruction(OpIndex),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "{1: 2}[2 - 1]",
+			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpHash, 2),
+				MakeInstruction(OpConstant, 2),
+				MakeInstruction(OpConstant, 3),
+				MakeInstruction(OpSub),
+				MakeInstruction(OpIndex),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+func TestCompileSliceExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[][1:]",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpArray, 0),
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpNull),
+				MakeInstruction(OpNull),
+				MakeInstruction(OpSlice),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             `""[:1:-1]`,
+			expectedConstants: []interface{}{"", 1, 1},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpNull),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpConstant, 2),
+				MakeInstruction(OpMinus),
+				MakeInstruction(OpSlice),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+func TestCompileMemberExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "null ?? 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpNull),
+				// 0001
+				MakeInstruction(OpJumpNotNull, 7),
+				// 0004
+				MakeInstruction(OpConstant, 0),
+				// 0007
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "{}.a?.b",
+			expectedConstants: []interface{}{"a", "b"},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpHash, 0),
+				MakeInstruction(OpMember, 0, 0),
+				MakeInstruction(OpJumpNull, 14),
+				MakeInstruction(OpMember, 1, 1),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			// ?.遇到null时跳过整个链
+			input:             "{}?.a.b(1)",
+			expectedConstants: []interface{}{"a", "b", 1},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpHash, 0),
+				MakeInstruction(OpJumpNull, 19),
+				MakeInstruction(OpMember, 0, 1),
+				MakeInstruction(OpMember, 1, 0),
+				MakeInstruction(OpConstant, 2),
+				MakeInstruction(OpCall, 1),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "let h = {}; h.a += 1",
+			expectedConstants: []interface{}{"a", 1},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpHash, 0),
+				MakeInstruction(OpSetGlobal, 0),
+				MakeInstruction(OpGetGlobal, 0),
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpSetIndex, int(OpAdd)),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input:             "1 ?? 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []Instructions{
+				// 0000
+				MakeInstruction(OpConstant, 0),
+				// 0003
+				MakeInstruction(OpJumpNotNull, 9),
+				// 0006
+				MakeInstruction(OpConstant, 1),
+				// 0009
+				MakeInstruction(OpReturnValue),
 			},
 		},
-		// {
-		// 	input:             "{1: 2}[2 - 1]",
-		// 	expectedConstants: []interface{}{1, 2, 2, 1},
-		// 	expectedInstructions: []Instructions{
-		// 		MakeInstruction(OpConstant, 0),
-		// 		MakeInstruction(OpConstant, 1),
-		// 		MakeInstruction(OpHash, 2),
-		// 		MakeInstruction(OpConstant, 2),
-		// 		MakeInstruction(OpConstant, 3),
-		// 		MakeInstruction(OpSub),
-		// 		MakeInstruction(OpIndex),
-		// 		MakeInstruction(OpPop),
-		// 	},
-		// },
 	}
 	runCompilerTests(t, tests)
 }
@@ -356,8 +786,8 @@ func TestCompileFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 2),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpClosure, 2, 0),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -373,8 +803,8 @@ func TestCompileFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 2),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpClosure, 2, 0),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -390,8 +820,8 @@ func TestCompileFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 2),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpClosure, 2, 0),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -402,8 +832,8 @@ func TestCompileFunctions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 0),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpClosure, 0, 0),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
@@ -421,9 +851,9 @@ func TestCompileFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpClosure, 1, 0),
 				MakeInstruction(OpCall, 0),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -438,11 +868,11 @@ func TestCompileFunctionCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 1),
+				MakeInstruction(OpClosure, 1, 0),
 				MakeInstruction(OpSetGlobal, 0),
 				MakeInstruction(OpGetGlobal, 0),
 				MakeInstruction(OpCall, 0),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -457,12 +887,12 @@ func TestCompileFunctionCalls(t *testing.T) {
 				24,
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpClosure, 0, 0),
 				MakeInstruction(OpSetGlobal, 0),
 				MakeInstruction(OpGetGlobal, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpCall, 1),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -483,14 +913,14 @@ func TestCompileFunctionCalls(t *testing.T) {
 				26,
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpClosure, 0, 0),
 				MakeInstruction(OpSetGlobal, 0),
 				MakeInstruction(OpGetGlobal, 0),
 				MakeInstruction(OpConstant, 1),
 				MakeInstruction(OpConstant, 2),
 				MakeInstruction(OpConstant, 3),
 				MakeInstruction(OpCall, 3),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
@@ -513,8 +943,8 @@ func TestCompileLetStatementScopes(t *testing.T) {
 			expectedInstructions: []Instructions{
 				MakeInstruction(OpConstant, 0),
 				MakeInstruction(OpSetGlobal, 0),
-				MakeInstruction(OpConstant, 1),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpClosure, 1, 0),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -534,8 +964,8 @@ func TestCompileLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
-				MakeInstruction(OpConstant, 1),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpClosure, 1, 0),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 		{
@@ -561,8 +991,184 @@ func TestCompileLetStatementScopes(t *testing.T) {
 				},
 			},
 			expectedInstructions: []Instructions{
+				MakeInstruction(OpClosure, 2, 0),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestCompileClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					a + b
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]Instructions{
+					MakeInstruction(OpGetFree, 0),
+					MakeInstruction(OpGetLocal, 0),
+					MakeInstruction(OpAdd),
+					MakeInstruction(OpReturnValue),
+				},
+				[]Instructions{
+					MakeInstruction(OpCaptureLocal, 0),
+					MakeInstruction(OpClosure, 0, 1),
+					MakeInstruction(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpClosure, 1, 0),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input: `
+			fn(a) {
+				fn(b) {
+					fn(c) {
+						a + b + c
+					}
+				}
+			};
+			`,
+			expectedConstants: []interface{}{
+				[]Instructions{
+					MakeInstruction(OpGetFree, 0),
+					MakeInstruction(OpGetFree, 1),
+					MakeInstruction(OpAdd),
+					MakeInstruction(OpGetLocal, 0),
+					MakeInstruction(OpAdd),
+					MakeInstruction(OpReturnValue),
+				},
+				[]Instructions{
+					MakeInstruction(OpCaptureFree, 0),
+					MakeInstruction(OpCaptureLocal, 0),
+					MakeInstruction(OpClosure, 0, 2),
+					MakeInstruction(OpReturnValue),
+				},
+				[]Instructions{
+					MakeInstruction(OpCaptureLocal, 0),
+					MakeInstruction(OpClosure, 1, 1),
+					MakeInstruction(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpClosure, 2, 0),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input: `
+			let global = 55;
+			fn() {
+				let a = 66;
+				fn() {
+					let b = 77;
+					a + b + global
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				55,
+				66,
+				77,
+				[]Instructions{
+					MakeInstruction(OpConstant, 2),
+					MakeInstruction(OpSetLocal, 0),
+					MakeInstruction(OpGetFree, 0),
+					MakeInstruction(OpGetLocal, 0),
+					MakeInstruction(OpAdd),
+					MakeInstruction(OpGetGlobal, 0),
+					MakeInstruction(OpAdd),
+					MakeInstruction(OpReturnValue),
+				},
+				[]Instructions{
+					MakeInstruction(OpConstant, 1),
+					MakeInstruction(OpSetLocal, 0),
+					MakeInstruction(OpCaptureLocal, 0),
+					MakeInstruction(OpClosure, 3, 1),
+					MakeInstruction(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpConstant, 0),
+				MakeInstruction(OpSetGlobal, 0),
+				MakeInstruction(OpClosure, 4, 0),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestCompileRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]Instructions{
+					MakeInstruction(OpCurrentClosure),
+					MakeInstruction(OpGetLocal, 0),
+					MakeInstruction(OpConstant, 0),
+					MakeInstruction(OpSub),
+					MakeInstruction(OpCall, 1),
+					MakeInstruction(OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpClosure, 1, 0),
+				MakeInstruction(OpSetGlobal, 0),
+				MakeInstruction(OpGetGlobal, 0),
 				MakeInstruction(OpConstant, 2),
-				MakeInstruction(OpPop),
+				MakeInstruction(OpCall, 1),
+				MakeInstruction(OpReturnValue),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]Instructions{
+					MakeInstruction(OpCurrentClosure),
+					MakeInstruction(OpGetLocal, 0),
+					MakeInstruction(OpConstant, 0),
+					MakeInstruction(OpSub),
+					MakeInstruction(OpCall, 1),
+					MakeInstruction(OpReturnValue),
+				},
+				1,
+				[]Instructions{
+					MakeInstruction(OpClosure, 1, 0),
+					MakeInstruction(OpSetLocal, 0),
+					MakeInstruction(OpGetLocal, 0),
+					MakeInstruction(OpConstant, 2),
+					MakeInstruction(OpCall, 1),
+					MakeInstruction(OpReturnValue),
+				},
+			},
+			expectedInstructions: []Instructions{
+				MakeInstruction(OpClosure, 3, 0),
+				MakeInstruction(OpSetGlobal, 0),
+				MakeInstruction(OpGetGlobal, 0),
+				MakeInstruction(OpCall, 0),
+				MakeInstruction(OpReturnValue),
 			},
 		},
 	}
@@ -582,7 +1188,7 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 		l := lexer.NewLexer(tt.input)
 		p := ast.NewParser(l)
 		program := p.ParseProgram()
-		comp := NewCompiler(NewSymbolTable(nil), []object.Object{})
+		comp := NewCompiler(NewSymbolTable(nil), []object.Object{}, nil)
 		if err := comp.Compile(program); err != nil {
 			t.Fatalf("compiler error: %s \ninput: %s", err, tt.input)
 		}
@@ -0,0 +1,33 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/alwaifu/monkey/pkg/lexer"
+	"github.com/alwaifu/monkey/pkg/object"
+)
+
+// RuntimeError 虚拟机执行时产生的错误
+type RuntimeError struct {
+	Pos      lexer.Position      // 出错指令对应的源码位置, 零值表示未知
+	Op       Opcode              // 出错的指令
+	Operands []object.ObjectType // 出错指令的操作数类型
+	Msg      string
+	Err      error // 导致中止执行的底层错误, 例如ctx.Err()
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Msg
+	}
+	return e.Msg
+}
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+func newRuntimeError(op Opcode, operands []object.Object, format string, a ...interface{}) *RuntimeError {
+	types := make([]object.ObjectType, 0, len(operands))
+	for _, o := range operands {
+		types = append(types, o.Type())
+	}
+	return &RuntimeError{Op: op, Operands: types, Msg: fmt.Sprintf(format, a...)}
+}
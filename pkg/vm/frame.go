@@ -7,25 +7,29 @@ import (
 )
 
 type Frame struct {
-	fn          *object.CompiledFunction
+	cl          *object.Closure
 	pc          int //program counter
 	basePointer int
+	loops       []int // 进入循环时的栈顶位置, 最内层的循环在最后
 }
 
-func NewFrame(fn *object.CompiledFunction, basePointer int) *Frame {
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
 	return &Frame{
-		fn:          fn,
+		cl:          cl,
 		pc:          0,
 		basePointer: basePointer,
 	}
 }
+func (f *Frame) Instructions() Instructions {
+	return f.cl.Fn.Instructions
+}
 func (f *Frame) readInsOprandUint8() uint8 {
-	oprand := f.fn.Instructions[f.pc]
+	oprand := f.cl.Fn.Instructions[f.pc]
 	f.pc++ //skip oprand
 	return oprand
 }
 func (f *Frame) readInsOprandUint16() uint16 {
-	oprand := binary.BigEndian.Uint16(f.fn.Instructions[f.pc:])
+	oprand := binary.BigEndian.Uint16(f.cl.Fn.Instructions[f.pc:])
 	f.pc += 2 //skip oprand
 	return oprand
 }
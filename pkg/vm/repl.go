@@ -14,10 +14,12 @@ func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	constants := []object.Object{}
 	globals := make([]object.Object, GlobalSize)
-	symbolTable := NewSymbolTable(nil)
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+	for i := range globals {
+		globals[i] = NULL
 	}
+	builtins := object.StandardBuiltins()
+	symbolTable := NewSymbolTable(nil)
+	symbolTable.DefineBuiltins(builtins)
 
 	for {
 		fmt.Fprint(out, "> ")
@@ -33,7 +35,7 @@ func Start(in io.Reader, out io.Writer) {
 			printErrors(out, p.Errors())
 			continue
 		}
-		compiler := NewCompiler(symbolTable, constants)
+		compiler := NewCompiler(symbolTable, constants, builtins)
 		if err := compiler.Compile(program); err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
@@ -43,13 +45,14 @@ func Start(in io.Reader, out io.Writer) {
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
 			continue
 		}
-		result := machine.stack[machine.sp]
-		_, _ = io.WriteString(out, result.Inspect())
-		_, _ = io.WriteString(out, "\n")
+		if result := machine.Result(); result != nil {
+			_, _ = io.WriteString(out, result.Inspect())
+			_, _ = io.WriteString(out, "\n")
+		}
 	}
 }
-func printErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		_, _ = io.WriteString(out, "\t"+msg+"\t")
+func printErrors(out io.Writer, errors ast.ErrorList) {
+	for _, err := range errors {
+		_, _ = io.WriteString(out, "\t"+err.Error()+"\n")
 	}
 }
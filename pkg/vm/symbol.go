@@ -1,11 +1,15 @@
 package vm
 
+import "github.com/alwaifu/monkey/pkg/object"
+
 type SymbolScope string
 
 const (
-	GlobalScope  SymbolScope = "GLOBAL"
-	LocalScope   SymbolScope = "LOCAL"
-	BuiltinScope SymbolScope = "BUILTIN"
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
 )
 
 type Symbol struct {
@@ -16,6 +20,9 @@ type Symbol struct {
 type SymbolTable struct {
 	outer *SymbolTable
 	store map[string]Symbol
+
+	numDefinitions int      // number of global or local var
+	FreeSymbols    []Symbol // symbols of outer scope captured by closure, in order of OpGetFree index
 }
 
 func NewSymbolTable(outer *SymbolTable) *SymbolTable {
@@ -26,25 +33,59 @@ func NewSymbolTable(outer *SymbolTable) *SymbolTable {
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
-	symbol := Symbol{Name: name, Index: len(s.store)}
+	// 同一作用域中重复定义的变量复用原来的位置, 与解释器中覆盖环境中的变量一致
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
 	}
 	s.store[name] = symbol
+	s.numDefinitions++
 	return symbol
 }
+
+// NumDefinitions returns number of global or local var defined in this table
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	if symbol, ok := s.store[name]; ok || s.outer == nil {
 		return symbol, ok
-	} else {
-		symbol, ok := s.outer.Resolve(name)
+	}
+	symbol, ok := s.outer.Resolve(name)
+	if !ok || symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
 		return symbol, ok
 	}
+	// local or free variable of outer function, capture it as free variable
+	return s.defineFree(symbol), true
 }
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	s.store[name] = symbol
 	return symbol
 }
+
+// DefineBuiltins defines all builtins of registry b at their positions
+func (s *SymbolTable) DefineBuiltins(b *object.Builtins) {
+	b.Range(func(i int, builtin *object.Builtin) bool {
+		s.DefineBuiltin(i, builtin.Name)
+		return true
+	})
+}
+
+// DefineFunctionName define name of current function, so it can reference itself recursively
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope}
+	s.store[original.Name] = symbol
+	return symbol
+}
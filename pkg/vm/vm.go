@@ -1,8 +1,12 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"slices"
 
+	"github.com/alwaifu/monkey/pkg/lexer"
 	"github.com/alwaifu/monkey/pkg/object"
 )
 
@@ -10,6 +14,12 @@ const StackSize = 2048
 const GlobalSize = 65536
 const FramesSize = 1024
 
+// MaxFrames 调用深度的上限, 超过时返回"stack overflow"运行时错误, 无限递归不会耗尽宿主的内存
+const MaxFrames = 1 << 14
+
+// MaxCallDepth 内置函数回调闭包(Call)嵌套的上限, 每层回调都会重新进入run并占用宿主的Go栈, 所以远小于MaxFrames
+const MaxCallDepth = 1 << 8
+
 var (
 	True  = object.True
 	False = object.False
@@ -18,6 +28,7 @@ var (
 
 type VM struct {
 	constants []object.Object
+	builtins  []*object.Builtin
 
 	stack []object.Object
 	sp    int
@@ -25,23 +36,71 @@ type VM struct {
 	globals []object.Object
 
 	frames []*Frame
+
+	ctx       context.Context
+	callErr   *RuntimeError // 内置函数回调时产生的运行时错误, 由调用该内置函数的OpCall返回
+	callDepth int           // 正在执行的Call的嵌套层数
+	result    object.Object // 程序的结果, 参见Result
 }
 
 func NewVM(c *Compiler, globals []object.Object) *VM {
-	mainFrame := NewFrame(&object.CompiledFunction{Instructions: c.scopes[c.scopeIndex].instructions}, 0)
+	return NewVMWithBytecode(c.Bytecode(), globals)
+}
+
+// NewVMWithBytecode creates vm to execute bytecode, bytecode is read only so it can be shared by many vm
+func NewVMWithBytecode(b *Bytecode, globals []object.Object) *VM {
+	mainFrame := NewFrame(&object.Closure{Fn: b.Main}, 0)
 	frames := make([]*Frame, 0, FramesSize)
 	frames = append(frames, mainFrame)
+	builtins := b.Builtins
+	if builtins == nil {
+		builtins = object.StandardBuiltins().List()
+	}
 	return &VM{
-		constants: c.Constants,
+		constants: b.Constants,
+		builtins:  builtins,
 		stack:     make([]object.Object, StackSize),
 		sp:        0,
 		globals:   globals,
 		frames:    frames,
+		ctx:       context.Background(),
 	}
 }
+
+// Reset prepares vm to execute its bytecode again with new globals, so vm can be reused
+func (vm *VM) Reset(globals []object.Object) {
+	clear(vm.stack)
+	vm.sp = 0
+	vm.frames = vm.frames[:1]
+	vm.frames[0].pc = 0
+	vm.frames[0].loops = vm.frames[0].loops[:0]
+	vm.globals = globals
+	vm.callErr = nil
+	vm.callDepth = 0
+	vm.result = nil
+}
 func (vm *VM) Run() error {
-	for caller := vm.frames[0]; caller.pc < len(caller.fn.Instructions); caller = vm.frames[len(vm.frames)-1] {
-		ins := caller.fn.Instructions[caller.pc]
+	return vm.RunContext(context.Background())
+}
+
+// RunContext executes bytecode until finished or ctx is done,
+// cancellation is checked on function calls and jumps.
+// The returned error is always *RuntimeError, which wraps ctx.Err() on cancellation
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.ctx = ctx
+	if err := vm.run(0); err != nil {
+		if !err.Pos.IsValid() {
+			err.Pos = vm.currentPos()
+		}
+		return err
+	}
+	return nil
+}
+
+// run executes instructions until the frames above depth return or the main frame is finished
+func (vm *VM) run(depth int) *RuntimeError {
+	for caller := vm.frames[len(vm.frames)-1]; len(vm.frames) > depth && caller.pc < len(caller.Instructions()); caller = vm.frames[len(vm.frames)-1] {
+		ins := caller.Instructions()[caller.pc]
 		caller.pc++
 
 		op := Opcode(ins)
@@ -59,42 +118,34 @@ func (vm *VM) Run() error {
 			} else {
 				vm.push(r)
 			}
-		case OpSub:
-			right := vm.pop().(object.Integer)
-			left := vm.pop().(object.Integer)
-			vm.push(object.Integer(left - right))
-		case OpMul:
-			right := vm.pop().(object.Integer)
-			left := vm.pop().(object.Integer)
-			vm.push(object.Integer(left * right))
-		case OpDiv:
-			right := vm.pop().(object.Integer)
-			left := vm.pop().(object.Integer)
-			vm.push(object.Integer(left / right))
+		case OpSub, OpMul, OpDiv, OpMod, OpPow, OpBitAnd, OpBitOr, OpBitXor, OpShl, OpShr:
+			right := vm.pop()
+			left := vm.pop()
+			if r, err := arithmetic(op, left, right); err != nil {
+				return err
+			} else {
+				vm.push(r)
+			}
 		case OpTrue:
 			vm.push(True)
 		case OpFalse:
 			vm.push(False)
-		case OpAnd:
-			right := isTruthy(vm.pop())
-			left := isTruthy(vm.pop())
-			vm.push(object.Boolean(left && right))
-		case OpOr:
-			right := isTruthy(vm.pop())
-			left := isTruthy(vm.pop())
-			vm.push(object.Boolean(left || right))
 		case OpEqual:
 			right := vm.pop()
 			left := vm.pop()
-			vm.push(object.Boolean(left == right))
+			vm.push(object.Boolean(equal(left, right)))
 		case OpNotEqual:
 			right := vm.pop()
 			left := vm.pop()
-			vm.push(object.Boolean(left != right))
-		case OpGt:
-			right := vm.pop().(object.Integer)
-			left := vm.pop().(object.Integer)
-			vm.push(object.Boolean(left > right))
+			vm.push(object.Boolean(!equal(left, right)))
+		case OpGt, OpGe, OpLt, OpLe:
+			right := vm.pop()
+			left := vm.pop()
+			if r, err := compare(op, left, right); err != nil {
+				return err
+			} else {
+				vm.push(r)
+			}
 		case OpBang:
 			operand := vm.pop()
 			if isTruthy(operand) {
@@ -103,15 +154,49 @@ func (vm *VM) Run() error {
 				vm.push(True)
 			}
 		case OpMinus:
-			vm.push(object.Integer(-vm.pop().(object.Integer)))
+			switch operand := vm.pop().(type) {
+			case object.Integer:
+				vm.push(-operand)
+			case object.Float:
+				vm.push(-operand)
+			default:
+				return newRuntimeError(op, []object.Object{operand}, "unknown operator: -%s", operand.Type())
+			}
+		case OpBitNot:
+			operand := vm.pop()
+			if i, ok := operand.(object.Integer); ok {
+				vm.push(^i)
+			} else {
+				return newRuntimeError(op, []object.Object{operand}, "unknown operator: ~%s", operand.Type())
+			}
 		case OpJump:
 			pos := int(caller.readInsOprandUint16())
 			caller.pc = pos //jump to pos
+			if err := vm.checkDone(op); err != nil {
+				return err
+			}
 		case OpJumpNotTruthy:
 			pos := int(caller.readInsOprandUint16())
 			if condition := vm.pop(); !isTruthy(condition) {
 				caller.pc = pos //jump to pos
 			}
+		case OpJumpTruthy:
+			pos := int(caller.readInsOprandUint16())
+			if condition := vm.pop(); isTruthy(condition) {
+				caller.pc = pos //jump to pos
+			}
+		case OpJumpNotNull:
+			pos := int(caller.readInsOprandUint16())
+			if vm.stack[vm.sp-1].Type() != object.NULL_OBJ {
+				caller.pc = pos //jump to pos, keep the value
+			} else {
+				vm.pop()
+			}
+		case OpJumpNull:
+			pos := int(caller.readInsOprandUint16())
+			if vm.stack[vm.sp-1].Type() == object.NULL_OBJ {
+				caller.pc = pos //jump to pos, keep the value
+			}
 		case OpNull:
 			vm.push(NULL)
 		case OpSetGlobal:
@@ -122,74 +207,341 @@ func (vm *VM) Run() error {
 			vm.push(vm.globals[idx])
 		case OpSetLocal:
 			idx := int(caller.readInsOprandUint8())
-			vm.stack[caller.basePointer+idx] = vm.pop()
+			if cell, ok := vm.stack[caller.basePointer+idx].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				vm.stack[caller.basePointer+idx] = vm.pop()
+			}
 		case OpGetLocal:
 			idx := int(caller.readInsOprandUint8())
-			vm.push(vm.stack[caller.basePointer+idx])
+			vm.push(deref(vm.stack[caller.basePointer+idx]))
+		case OpCaptureLocal:
+			// 第一次被捕获时装箱, 之后外层函数和所有闭包通过同一个cell读写
+			idx := int(caller.readInsOprandUint8())
+			cell, ok := vm.stack[caller.basePointer+idx].(*object.Cell)
+			if !ok {
+				cell = &object.Cell{Value: vm.stack[caller.basePointer+idx]}
+				vm.stack[caller.basePointer+idx] = cell
+			}
+			vm.push(cell)
 		case OpArray:
 			numElements := int(caller.readInsOprandUint16())
 			arr := make([]object.Object, 0, numElements)
 			arr = append(arr, vm.stack[vm.sp-numElements:vm.sp]...)
 			vm.sp = vm.sp - numElements
 			vm.push(&object.Array{Elements: arr})
+		case OpHash:
+			numElements := int(caller.readInsOprandUint16())
+			hash := object.NewHash(numElements / 2)
+			for i := vm.sp - numElements; i < vm.sp; i += 2 {
+				key, ok := vm.stack[i].(object.Hashable)
+				if !ok {
+					return newRuntimeError(op, vm.stack[i:i+1], "unusable as hash key: %s", vm.stack[i].Type())
+				}
+				hash.Set(key, vm.stack[i+1])
+			}
+			vm.sp = vm.sp - numElements
+			vm.push(hash)
 		case OpIndex:
 			index := vm.pop()
 			left := vm.pop()
-			switch {
-			case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-				arr := left.(*object.Array)
-				index := index.(object.Integer)
-				if index < 0 || int(index) >= len(arr.Elements) {
-					return fmt.Errorf("array index out of bounds: %d", index)
+			value, err := getIndex(op, left, index)
+			if err != nil {
+				return err
+			}
+			vm.push(value)
+		case OpSlice:
+			bounds := vm.stack[vm.sp-4 : vm.sp]
+			value := object.Slice(bounds[0], bounds[1], bounds[2], bounds[3])
+			if err, ok := value.(*object.Error); ok {
+				return newRuntimeError(op, bounds, "%s", err.Message)
+			}
+			vm.sp -= 4
+			vm.push(value)
+		case OpMember:
+			name := vm.constants[caller.readInsOprandUint16()].(object.String)
+			optional := caller.readInsOprandUint8() == 1
+			obj := vm.pop()
+			value := object.Member(obj, string(name), optional)
+			if err, ok := value.(*object.Error); ok {
+				return newRuntimeError(op, []object.Object{obj}, "%s", err.Message)
+			}
+			vm.push(value)
+		case OpSetIndex:
+			arithmeticOp := Opcode(caller.readInsOprandUint8())
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			if arithmeticOp != 0 {
+				old, err := getIndex(op, left, index)
+				if err != nil {
+					return err
+				}
+				if arithmeticOp == OpAdd {
+					value, err = add(old, value)
+				} else {
+					value, err = arithmetic(arithmeticOp, old, value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if err := setIndex(op, left, index, value); err != nil {
+				return err
+			}
+			vm.push(value)
+		case OpToString:
+			if value := vm.pop(); value.Type() == object.STRING_OBJ {
+				vm.push(value)
+			} else {
+				vm.push(object.String(value.Inspect()))
+			}
+		case OpMatchLiteral:
+			literal := vm.constants[caller.readInsOprandUint16()].(object.Hashable)
+			value, ok := vm.pop().(object.Hashable)
+			vm.push(object.Boolean(ok && value.Type() == literal.Type() && value.HashKey() == literal.HashKey()))
+		case OpMatchArray:
+			length := int(caller.readInsOprandUint16())
+			arr, ok := vm.pop().(*object.Array)
+			vm.push(object.Boolean(ok && len(arr.Elements) == length))
+		case OpMatchHash:
+			keys := vm.constants[caller.readInsOprandUint16()].(*object.Array)
+			vm.push(object.Boolean(hasKeys(vm.pop(), keys.Elements)))
+		case OpJumpTable:
+			table := vm.constants[caller.readInsOprandUint16()].(*object.Hash)
+			defaultPos := int(caller.readInsOprandUint16())
+			caller.pc = defaultPos
+			if value, ok := vm.pop().(object.Hashable); ok {
+				// 1.0与1的键相同, 字面量模式还要求类型相同
+				if pair, ok := table.Pairs[value.HashKey()]; ok && pair.Key.Type() == value.Type() {
+					caller.pc = int(pair.Value.(object.Integer))
 				}
-				vm.push(arr.Elements[index])
-			// TODO: implement OpIndex for map
-			default:
-				return fmt.Errorf("index operator not supported: %s", left.Type())
 			}
 		case OpCall:
 			numArgs := int(caller.readInsOprandUint8())
+			if err := vm.checkDone(op); err != nil {
+				return err
+			}
 			fn := vm.stack[vm.sp-numArgs-1]
 			switch fn := fn.(type) {
-			case *object.CompiledFunction:
-				if numArgs != fn.NumParameters {
-					return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
+			case *object.Closure:
+				if numArgs != fn.Fn.NumParameters {
+					return newRuntimeError(op, []object.Object{fn}, "wrong number of arguments: want=%d, got=%d", fn.Fn.NumParameters, numArgs)
+				}
+				if err := vm.pushFrame(fn, numArgs); err != nil {
+					return err
 				}
-				callee := NewFrame(fn, vm.sp-numArgs)
-				vm.frames = append(vm.frames, callee)
-				vm.sp = callee.basePointer + fn.NumLocals
 			case *object.Builtin:
 				args := vm.stack[vm.sp-numArgs : vm.sp]
-				result := fn.Fn(args...)
+				vm.callErr = nil
+				result := fn.Call(vm, args...)
+				if result, ok := result.(*object.Error); ok {
+					if err := vm.callErr; err != nil {
+						vm.callErr = nil
+						return err
+					}
+					if err := vm.checkDone(op); err != nil {
+						return err
+					}
+					return newRuntimeError(op, []object.Object{fn}, "%s", result.Message)
+				}
 				vm.sp = vm.sp - numArgs - 1
 				vm.push(result)
 			default:
-				return fmt.Errorf("calling non-function: %T", vm.stack[vm.sp-1])
+				return newRuntimeError(op, []object.Object{fn}, "not a function: %s", fn.Type())
 			}
 		case OpReturnValue:
 			returnValue := vm.pop()
+			if len(vm.frames) == 1 {
+				// 主程序中的return结束整个程序, 返回值为程序的结果
+				vm.result = returnValue
+				vm.stack[vm.sp] = returnValue
+				caller.pc = len(caller.Instructions())
+				continue
+			}
 			vm.sp = vm.frames[len(vm.frames)-1].basePointer - 1
 			vm.frames = vm.frames[:len(vm.frames)-1]
 			vm.push(returnValue)
 		case OpReturn:
 			vm.sp = vm.frames[len(vm.frames)-1].basePointer - 1
 			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.push(NULL)
 		case OpGetBuiltin:
 			idx := int(caller.readInsOprandUint8())
-			vm.push(object.Builtins[idx].Builtin)
+			vm.push(vm.builtins[idx])
+		case OpClosure:
+			constIdx := caller.readInsOprandUint16()
+			numFree := int(caller.readInsOprandUint8())
+			fn, ok := vm.constants[constIdx].(*object.CompiledFunction)
+			if !ok {
+				return newRuntimeError(op, vm.constants[constIdx:constIdx+1], "not a function: %s", vm.constants[constIdx].Type())
+			}
+			free := make([]object.Object, numFree)
+			copy(free, vm.stack[vm.sp-numFree:vm.sp])
+			vm.sp = vm.sp - numFree
+			vm.push(&object.Closure{Fn: fn, Free: free})
+		case OpGetFree:
+			idx := int(caller.readInsOprandUint8())
+			vm.push(deref(caller.cl.Free[idx]))
+		case OpCaptureFree:
+			idx := int(caller.readInsOprandUint8())
+			vm.push(caller.cl.Free[idx])
+		case OpSetFree:
+			idx := int(caller.readInsOprandUint8())
+			if cell, ok := caller.cl.Free[idx].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				caller.cl.Free[idx] = vm.pop()
+			}
+		case OpCurrentClosure:
+			vm.push(caller.cl)
+		case OpLoopEnter:
+			caller.loops = append(caller.loops, vm.sp)
+		case OpLoopUnwind:
+			vm.sp = caller.loops[len(caller.loops)-1]
+		case OpLoopExit:
+			caller.loops = caller.loops[:len(caller.loops)-1]
+		case OpIter:
+			iterable := vm.pop()
+			iter := object.NewIterator(iterable)
+			if iter == nil {
+				return newRuntimeError(op, []object.Object{iterable}, "not iterable: %s", iterable.Type())
+			}
+			vm.push(iter)
+		case OpIterNext:
+			pos := int(caller.readInsOprandUint16())
+			iter, ok := vm.stack[vm.sp-1].(*object.Iterator)
+			if !ok {
+				return newRuntimeError(op, vm.stack[vm.sp-1:vm.sp], "not an iterator: %s", vm.stack[vm.sp-1].Type())
+			}
+			if value, ok := iter.Next(); ok {
+				vm.push(value)
+			} else {
+				vm.pop()
+				caller.pc = pos //jump to pos
+			}
 		default:
-			return fmt.Errorf("unsupported opcode: %d", op)
+			return newRuntimeError(op, nil, "unsupported opcode: %s", op)
 		}
 	}
 	return nil
 }
+
+// pushFrame enters closure cl whose arguments are on top of stack,
+// it fails when the call depth exceeds MaxFrames
+func (vm *VM) pushFrame(cl *object.Closure, numArgs int) *RuntimeError {
+	if len(vm.frames) >= MaxFrames {
+		return newRuntimeError(OpCall, []object.Object{cl}, "stack overflow")
+	}
+	callee := NewFrame(cl, vm.sp-numArgs)
+	vm.frames = append(vm.frames, callee)
+	vm.sp = callee.basePointer + cl.Fn.NumLocals
+	for vm.sp >= len(vm.stack) {
+		vm.growStack()
+	}
+	// 未赋值的局部变量为null, 同时避免OpSetLocal写入之前调用遗留的cell
+	for i := callee.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = NULL
+	}
+	return nil
+}
+
+// deref 返回cell中的值, 其它值原样返回
+func deref(obj object.Object) object.Object {
+	if cell, ok := obj.(*object.Cell); ok {
+		return cell.Value
+	}
+	return obj
+}
+
+var _ object.Context = (*VM)(nil)
+
+// Call applies fn to args on top of current stack, so builtins can call back into closures.
+// Runtime errors of fn (including cancellation) are returned as *object.Error,
+// and the original *RuntimeError is returned by the OpCall which called the builtin
+func (vm *VM) Call(fn object.Object, args ...object.Object) object.Object {
+	if err := vm.checkDone(OpCall); err != nil {
+		vm.callErr = err
+		return &object.Error{Message: err.Msg}
+	}
+	switch fn := fn.(type) {
+	case *object.Closure:
+		if len(args) != fn.Fn.NumParameters {
+			return &object.Error{Message: fmt.Sprintf("wrong number of arguments: want=%d, got=%d", fn.Fn.NumParameters, len(args))}
+		}
+		depth, sp := len(vm.frames), vm.sp
+		vm.push(fn)
+		for _, arg := range args {
+			vm.push(arg)
+		}
+		var err *RuntimeError
+		if vm.callDepth >= MaxCallDepth {
+			err = newRuntimeError(OpCall, []object.Object{fn}, "stack overflow")
+		} else if err = vm.pushFrame(fn, len(args)); err == nil {
+			vm.callDepth++
+			err = vm.run(depth)
+			vm.callDepth--
+		}
+		if err != nil {
+			if !err.Pos.IsValid() {
+				err.Pos = vm.currentPos()
+			}
+			vm.frames, vm.sp = vm.frames[:depth], sp
+			vm.callErr = err
+			return &object.Error{Message: err.Msg, Pos: err.Pos}
+		}
+		return vm.pop()
+	case *object.Builtin:
+		return fn.Call(vm, args...)
+	default:
+		return &object.Error{Message: fmt.Sprintf("not a function: %s", fn.Type())}
+	}
+}
+
+// Err returns error of context if execution is canceled
+func (vm *VM) Err() error {
+	return vm.ctx.Err()
+}
+
+// checkDone returns error of context if it is done
+func (vm *VM) checkDone(op Opcode) *RuntimeError {
+	select {
+	case <-vm.ctx.Done():
+		return &RuntimeError{Op: op, Msg: vm.ctx.Err().Error(), Err: vm.ctx.Err()}
+	default:
+		return nil
+	}
+}
+
+// LastPopped returns the value of last executed expression statement
+func (vm *VM) LastPopped() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Result returns the value of the last top-level expression statement or top-level return,
+// it is nil if the program has neither
+func (vm *VM) Result() object.Object {
+	return vm.result
+}
+
+// currentPos returns source position of the instruction being executed
+func (vm *VM) currentPos() lexer.Position {
+	frame := vm.frames[len(vm.frames)-1]
+	return frame.cl.Fn.PosAt(frame.pc - 1)
+}
 func (vm *VM) push(o object.Object) {
 	if vm.sp >= len(vm.stack) {
-		vm.stack = append(vm.stack, make([]object.Object, StackSize)...)
+		vm.growStack()
 	}
 	vm.stack[vm.sp] = o
 	vm.sp++
 }
+
+// growStack 将栈扩展StackSize个槽位,
+// 不直接append(make(...)...), 否则-race下每个内联的push都会在run的栈帧上分配一个StackSize的数组
+func (vm *VM) growStack() {
+	vm.stack = slices.Grow(vm.stack, StackSize)[:len(vm.stack)+StackSize]
+}
 func (vm *VM) pop() object.Object {
 	vm.sp--
 	return vm.stack[vm.sp]
@@ -205,12 +557,196 @@ func isTruthy(obj object.Object) bool {
 		return true
 	}
 }
-func add(left, right object.Object) (object.Object, error) {
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		return left.(object.Integer) + right.(object.Integer), nil
-	} else if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+
+// hasKeys 判断obj是否为包含所有键的哈希表
+func hasKeys(obj object.Object, keys []object.Object) bool {
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		return false
+	}
+	for _, key := range keys {
+		if _, ok := hash.Get(key.(object.Hashable)); !ok {
+			return false
+		}
+	}
+	return true
+}
+func getIndex(op Opcode, left, index object.Object) (object.Object, *RuntimeError) {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arr := left.(*object.Array)
+		i, ok := arr.Index(int(index.(object.Integer)))
+		if !ok {
+			return nil, newRuntimeError(op, []object.Object{arr, index}, "array index out of bounds: %d", index)
+		}
+		return arr.Elements[i], nil
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		str, ok := left.(object.String).At(int(index.(object.Integer)))
+		if !ok {
+			return nil, newRuntimeError(op, []object.Object{left, index}, "string index out of bounds: %d", index)
+		}
+		return str, nil
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return nil, newRuntimeError(op, []object.Object{left, index}, "unusable as hash key: %s", index.Type())
+		}
+		if value, ok := left.(*object.Hash).Get(key); ok {
+			return value, nil
+		}
+		return NULL, nil
+	case left.Type() == object.RECORD_OBJ:
+		value := left.(*object.Record).Index(index)
+		if err, ok := value.(*object.Error); ok {
+			return nil, newRuntimeError(op, []object.Object{left, index}, "%s", err.Message)
+		}
+		return value, nil
+	default:
+		return nil, newRuntimeError(op, []object.Object{left, index}, "index operator not supported: %s", left.Type())
+	}
+}
+func setIndex(op Opcode, left, index, value object.Object) *RuntimeError {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		arr := left.(*object.Array)
+		i, ok := arr.Index(int(index.(object.Integer)))
+		if !ok {
+			return newRuntimeError(op, []object.Object{arr, index}, "array index out of bounds: %d", index)
+		}
+		arr.Elements[i] = value
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newRuntimeError(op, []object.Object{left, index}, "unusable as hash key: %s", index.Type())
+		}
+		left.(*object.Hash).Set(key, value)
+	default:
+		return newRuntimeError(op, []object.Object{left, index, value}, "index assignment not supported: %s", left.Type())
+	}
+	return nil
+}
+func add(left, right object.Object) (object.Object, *RuntimeError) {
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
 		return left.(object.String) + right.(object.String), nil
-	} else {
-		return NULL, fmt.Errorf("type mismatch: %s + %s", left.Type(), right.Type())
 	}
+	return arithmetic(OpAdd, left, right)
+}
+
+// operators 二元运算指令对应的运算符, 用于错误信息
+var operators = map[Opcode]string{
+	OpAdd: "+", OpSub: "-", OpMul: "*", OpDiv: "/", OpMod: "%", OpPow: "**",
+	OpBitAnd: "&", OpBitOr: "|", OpBitXor: "^", OpShl: "<<", OpShr: ">>",
+	OpGt: ">", OpGe: ">=", OpLt: "<", OpLe: "<=",
+}
+
+// arithmetic 整数运算结果为整数, 整数与浮点数混合运算时整数转换为浮点数, 位运算只能用于整数
+func arithmetic(op Opcode, left, right object.Object) (object.Object, *RuntimeError) {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		l, r := left.(object.Integer), right.(object.Integer)
+		switch op {
+		case OpAdd:
+			return l + r, nil
+		case OpSub:
+			return l - r, nil
+		case OpMul:
+			return l * r, nil
+		case OpDiv:
+			if r == 0 {
+				return NULL, newRuntimeError(op, []object.Object{left, right}, "division by zero")
+			}
+			return l / r, nil
+		case OpMod:
+			if r == 0 {
+				return NULL, newRuntimeError(op, []object.Object{left, right}, "modulo by zero")
+			}
+			return l % r, nil
+		case OpPow:
+			if r < 0 {
+				return object.Float(math.Pow(float64(l), float64(r))), nil
+			}
+			return l.Pow(r), nil
+		case OpBitAnd:
+			return l & r, nil
+		case OpBitOr:
+			return l | r, nil
+		case OpBitXor:
+			return l ^ r, nil
+		case OpShl, OpShr:
+			if r < 0 {
+				return NULL, newRuntimeError(op, []object.Object{left, right}, "negative shift count: %d", r)
+			}
+			if op == OpShl {
+				return l << r, nil
+			}
+			return l >> r, nil
+		}
+	case isNumber(left) && isNumber(right):
+		l, r := toFloat(left), toFloat(right)
+		switch op {
+		case OpAdd:
+			return l + r, nil
+		case OpSub:
+			return l - r, nil
+		case OpMul:
+			return l * r, nil
+		case OpDiv:
+			return l / r, nil
+		case OpMod:
+			return object.Float(math.Mod(float64(l), float64(r))), nil
+		case OpPow:
+			return object.Float(math.Pow(float64(l), float64(r))), nil
+		}
+	}
+	return NULL, operatorError(op, left, right)
+}
+
+// compare 比较整数, 浮点数(可与整数混合比较)或字符串(按字节序)
+func compare(op Opcode, left, right object.Object) (object.Object, *RuntimeError) {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return compareOrdered(op, left.(object.Integer), right.(object.Integer)), nil
+	case isNumber(left) && isNumber(right):
+		return compareOrdered(op, toFloat(left), toFloat(right)), nil
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return compareOrdered(op, left.(object.String), right.(object.String)), nil
+	}
+	return NULL, operatorError(op, left, right)
+}
+func compareOrdered[T object.Integer | object.Float | object.String](op Opcode, left, right T) object.Boolean {
+	switch op {
+	case OpGt:
+		return left > right
+	case OpGe:
+		return left >= right
+	case OpLt:
+		return left < right
+	default:
+		return left <= right
+	}
+}
+
+// equal 整数与浮点数按数值比较, 其他对象按值(基础类型)或引用(指针类型)比较
+func equal(left, right object.Object) bool {
+	if isNumber(left) && isNumber(right) && left.Type() != right.Type() {
+		return toFloat(left) == toFloat(right)
+	}
+	return left == right
+}
+func isNumber(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ
+}
+func toFloat(obj object.Object) object.Float {
+	if i, ok := obj.(object.Integer); ok {
+		return object.Float(i)
+	}
+	return obj.(object.Float)
+}
+func operatorError(op Opcode, left, right object.Object) *RuntimeError {
+	operands := []object.Object{left, right}
+	if left.Type() != right.Type() {
+		return newRuntimeError(op, operands, "type mismatch: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+	return newRuntimeError(op, operands, "unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
 }
@@ -1,10 +1,12 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/alwaifu/monkey/pkg/ast"
+	"github.com/alwaifu/monkey/pkg/interpreter"
 	"github.com/alwaifu/monkey/pkg/lexer"
 	"github.com/alwaifu/monkey/pkg/object"
 )
@@ -33,6 +35,49 @@ func TestRunIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"12345 % 100", 45},
+		{"2 ** 10", 1024},
+		{"-2 ** 2", -4},
+		{"2 ** 3 ** 2", 512},
+		{"3 ** 0", 1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"1 << 2 + 1", 8},
+		{"5 & 1 + 2", 1},
+		{"2 ** -1", "0.5"},
+		{"7.5 % 2", "1.5"},
+		{"2 ** 0.5 * 2 ** 0.5", "2.0000000000000004"},
+		{"let user_id = 1234; user_id % 100 < 10", false},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunFloatArithmetic(t *testing.T) {
+	testCases := []vmTestCase{
+		{"3.14", "3.14"},
+		{"1e-9", "1e-09"},
+		{"1.5 + 1.5", "3.0"},
+		{"1 + 0.5", "1.5"},
+		{"0.5 * 4", "2.0"},
+		{"7 / 2", 7 / 2},
+		{"7 / 2.0", "3.5"},
+		{"-2.5 - 1", "-3.5"},
+		{"1.5 > 1", true},
+		{"1 < 1.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"[1.5][0]", "1.5"},
+		{`{1.5: "a"}[1.5]`, "a"},
+		{"int(2.9)", 2},
+		{`int("42")`, 42},
+		{`float("2.5") * 2`, "5.0"},
+		{"float(1)", "1.0"},
+		{"str(2.0)", "2.0"},
 	}
 	runVmTests(t, testCases)
 }
@@ -67,6 +112,218 @@ func TestRunBooleanExpressions(t *testing.T) {
 	}
 	runVmTests(t, testCases)
 }
+func TestRunComparisons(t *testing.T) {
+	testCases := []vmTestCase{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"1 >= 0.5", true},
+		{`"a" < "b"`, true},
+		{`"b" <= "a"`, false},
+		{`"abc" > "ab"`, true},
+		{`"a" >= "a"`, true},
+	}
+	runVmTests(t, testCases)
+}
+
+// TestEngineParity 解释器与虚拟机的执行结果(包括错误信息)应当一致
+func TestEngineParity(t *testing.T) {
+	inputs := []string{
+		"1 < 2", "2 < 1", "1 <= 1", "1 > 2", "2 >= 3", "1 == 1", "1 != 2",
+		"1.5 < 2", "2 <= 1.5", "0.5 > 0.25", "1 >= 1.0", "1 == 1.0",
+		`"a" < "b"`, `"b" <= "a"`, `"b" > "a"`, `"a" >= "b"`, `"a" == "a"`,
+		`1 < "a"`, `"a" >= 1`, "true > false", "[1] <= [2]",
+		`"a" - 1`, `"a" * "b"`, "true / false", "-true", `-"a"`, "-[1]",
+		"1 / 0", "1.0 / 0 > 1", "5 + true", "[1][true]", "1[0]", `{"a": 1}[[1]]`,
+		"1(2)", `"f"()`, "fn(x) { x }()", "fn() { 1 }(2)",
+		"for (x in 1) {}", `let s = ""; for (x in {1: 2, "a": 3}) { let s = s + str(x) }; s`,
+		"let x = 1; x += 2; x", "let a = [1, 2]; a[0] = a[1] = 3; a", `let h = {}; h["k"] = 1; h["k"] *= 4; h`,
+		`let x = 1; x += "a"`, "let a = [1]; a[1] = 2", `let s = "a"; s[0] = "b"`, "let h = {}; h[[1]] = 1",
+		"match (1) { 1.0 => 1, 1 => 2 }", `match ([1, {"a": [2]}]) { [x, {"a": [y]}] => x + y }`, "match (3) { 1 => 1, 2 => 2 }",
+		`match ({"a": 1}) { {"a": "x"} => 1, {"a": a} if a > 0 => a * 10 }`, "match (0) { x if 1 / x => 1 }",
+		`"${1}${2.0}${[1]}${{"a": 1}}"`, `"${-true}"`, `let x = "a"; "${x}" == x`,
+		"7 % 0", "-7 % 2.5", "2 ** -2", "3 ** 40", "1 & 2.0", "true | false", `"a" ** 2`, "~true", "1 >> -2", "1 << 64",
+		"first([])", "rest([1, 2])", "push([1], 2)", "pop([1, 2])", "slice([1, 2, 3], 1, 2)", `slice("价格", 1)`, "concat([1], [2])", "reverse([1, 2])",
+		"contains([1], 1.0)", "index_of([1, 2], 2)", "sort([2, 1.5])", "zip([1, 2], [3])", "reduce([], fn(a, b) { a })",
+		"map([1, 2], fn(x) { x * x })", "filter([1, 2, 3], fn(x) { x != 2 })", "reduce([1, 2], fn(a, b) { a - b }, 10)", "any([1, 2], fn(x) { x > 1 })", "all([0, 1])",
+		`contains("abc", "b")`, `starts_with("ab", "a")`, `ends_with("ab", "a")`, `split("a,b", ",")`, `join([1, 2], ", ")`, `trim(" a ")`,
+		`upper("a")`, `lower("A")`, `replace("aa", "a", "b")`, `substr("价格", 1, 5)`, `sprintf("%05.1f", 3.14159)`,
+		`matches("abc", "b+")`, `find_all("a1b2", "\\d")`, `replace_re("a1b2", "\\d", "#")`,
+		"sort([true])", "len(1)", "map([1, 0], fn(x) { 1 / x })", "filter([1], fn() { 1 })", `reduce([1, 2], fn(a, b) { a + b }, "x")`,
+		`let h = {"a": {"b": 1}}; h.a.b`, `{"a": 1}.b`, `{}.a.b`, "[1].a", "1?.a", `{}?.a?.b`, `let h = {}; h.a = {}; h.a.b = 2; h.a.b *= 3; h`,
+		`let m = {"f": fn(x) { x + 1 }}; m.f(1)`, "0 ?? 1", `{}.a ?? "x"`, `{"a": false}.a ?? 1`, "1 ?? 1 / 0", `{}.a ?? {}.b ?? 3 > 2`,
+		"[1, 2, 3][3]", "[1, 2, 3][-4]", `"价格"[2]`, `"价格"[-3]`, "let a = [1]; a[-2] = 1", "[1, 2, 3][-1]",
+		"[1, 2, 3, 4][1:3]", "[1, 2, 3, 4][-10:10]", "[1, 2, 3, 4][3:1]", "[1, 2, 3, 4][:0:-2]", "[1, 2][::0]", `[1, 2]["a":]`, "1[1:]",
+		`"价格表"[1::-1]`, `"abc"[::]`, "slice([1, 2, 3], -2)", "slice([1, 2], 2, 1)", `slice("a", "b")`,
+		"{1: 5}[1.0]", "{2.0: 5}", "{1: 1, 1.0: 2}", "let h = {}; h[3.0] = 1; h[3]", `match (1.0) { 1 => "int", 1.0 => "float" }`, `match (1.0) { 1 => "int", "a" => "str" }`,
+		"let s = 0; for (i in [1, 2, 3]) { s = s + [1, if (i == 2) { continue } else { i }][1] }; s",
+		"let s = 0; for (i in [1, 2, 3]) { s = s + [1, if (i == 2) { break } else { i }][1] }; s",
+		"let s = []; let i = 0; while (i < 4) { i += 1; s = push(s, match (i) { 2 => continue, 4 => break, x => x * 10 }) }; [s, i]",
+		"let f = fn() { 1 + if (true) { return 5 } }; f()", "[if (true) {}]", "let x = if (true) { let y = 1 }; x",
+		"range(5)", "range(1, 10, 3)", "len(range(10, 0, -3))", "map(range(3), fn(x) { x * 2 })", "contains(range(0, 10, 2), 4)",
+		"let s = 0; for (i in range(10, 0, -3)) { s += i }; s", "let n = 0; for (i in range(1 << 40)) { if (i == 3) { break } n += 1 }; n",
+		"map(range(1 << 40), fn(x) { x })", "range(3)[0]",
+		"let f = fn() { let x = 0; let inc = fn() { x = x + 1; x }; inc(); inc(); x }; f()",
+		"let f = fn() { let x = 1; let g = fn() { fn() { x *= 10 } }; g()(); x += 1; g()(); x }; f()",
+		"let f = fn(n) { let get = fn() { n }; n = n + 1; get() }; f(1)",
+		"let f = fn() { let fs = []; for (i in [1, 2]) { fs = push(fs, fn() { i }) }; map(fs, fn(g) { g() }) }; f()",
+		"let h = {}; h?.a.b", "let h = {}.h; h?.a.b", "let h = {}; h.a?.b.c(1)[0][1:]", `let h = {"a": {"b": 1}}; h?.a.b`, "let h = {}; h.a?.b + 1",
+		"let h = {}; h.a.b", "let h = {}; [h.a?.b.c ?? 5, (h.a?.b).c]", "let f = fn(h) { h?.a.b }; [f({}), f({\"a\": {\"b\": 2}})]",
+		"return 1; 2", "let f = fn() { 5 }; if (true) { return f() + 1 }; 0", "for (x in [1, 2, 3]) { if (x == 2) { return x } }; 0",
+		"let f = fn(c) { if (c) { let x = 1 }; x }; f(true)", "for (i in [1, 2]) { let y = i * 10 }; y", "let g = fn() { for (i in [1, 2]) { let z = i }; z }; g()",
+		"{1: 1, 1.0: 2, 2.0: 3, 2: 4}", `let h = {1: "a"}; h[1.0] = "b"; h`, "let h = {1: 1}; h[1.0] += 1; let ks = []; for (k in h) { ks = push(ks, k) }; ks",
+		"null", `[null, {"a": null}.a == null, 1 == null, null != false, !null]`, "null ?? 1", "{}.a ?? null", "null?.a.b", "null.a", "null + 1", "null < 1",
+		"1 and 2", "0 or false", "false and 1 / 0", "true or 1 / 0", "true and 1 / 0", `false or "a" - 1`,
+	}
+	for _, input := range inputs {
+		program := ast.NewParser(lexer.NewLexer(input)).ParseProgram()
+		want := interpreter.Eval(program, object.NewEnviroment())
+		expected := want.Inspect()
+		if err, ok := want.(*object.Error); ok {
+			expected = err.Message
+		}
+
+		comp := NewCompiler(nil, []object.Object{}, nil)
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("input %q: compiler error: %s", input, err)
+		}
+		vm := NewVM(comp, make([]object.Object, GlobalSize))
+		var got string
+		if err := vm.Run(); err != nil {
+			got = err.(*RuntimeError).Msg // 去掉位置信息
+		} else {
+			got = vm.LastPopped().Inspect()
+		}
+		if got != expected {
+			t.Errorf("input %q: interpreter=%q, vm=%q", input, expected, got)
+		}
+	}
+}
+func TestRuntimeError(t *testing.T) {
+	program := ast.NewParser(lexer.NewFileLexer("rules.mk", `let a = "x"; a - 1`)).ParseProgram()
+	comp := NewCompiler(nil, []object.Object{}, nil)
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := NewVM(comp, make([]object.Object, GlobalSize)).Run()
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if rerr.Op != OpSub {
+		t.Errorf("wrong opcode. want=%s, got=%s", OpSub, rerr.Op)
+	}
+	if len(rerr.Operands) != 2 || rerr.Operands[0] != object.STRING_OBJ || rerr.Operands[1] != object.INTEGER_OBJ {
+		t.Errorf("wrong operand types. got=%v", rerr.Operands)
+	}
+	if rerr.Pos.String() != "rules.mk:1:16" {
+		t.Errorf("wrong position. got=%s", rerr.Pos)
+	}
+	if rerr.Error() != "rules.mk:1:16: type mismatch: STRING - INTEGER" {
+		t.Errorf("wrong message. got=%q", rerr.Error())
+	}
+}
+func TestRunLogicalOperators(t *testing.T) {
+	testCases := []vmTestCase{
+		{"true and true", true},
+		{"true and false", false},
+		{"false or true", true},
+		{"false or false", false},
+		{"true or false and true", true},
+		{"false or true and false or false", false},
+		{"1 and \"a\"", true},
+		{"0 or false", true},
+		{"false or if (false) { 1 }", false},
+		{"false and 1 / 0", false},
+		{"true or 1 / 0", true},
+		{"let x = [1]; len(x) > 0 and x[0] > 0", true},
+		{"let x = []; len(x) > 0 and x[0] > 0", false},
+		{"let f = fn(a) { a or false }; f(1) and f(0) and !f(false)", true},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunLoops(t *testing.T) {
+	testCases := []vmTestCase{
+		{"let s = 0; for (x in [1, 2, 3]) { let s = s + x }; s", 6},
+		{`let r = ""; for (c in "héllo") { let r = c + r }; r`, "olléh"},
+		{`let r = ""; for (k in {"a": 1, "b": 2}) { let r = r + k }; r`, "ab"},
+		{"let s = 0; for (i in range(5)) { let s = s + i }; s", 10},
+		{"let s = 0; for (i in range(100000)) { let s = s + i }; s", 4999950000},
+		{"let s = 0; for (i in range(10)) { if (i == 5) { break }; if (i == 2) { continue }; let s = s + i }; s", 8},
+		{"let s = 0; for (i in range(3)) { for (j in range(3)) { if (j > i) { break } let s = s + 1 } }; s", 6},
+		{"let i = 0; while (i < 5) { let i = i + 1 }; i", 5},
+		{"let i = 0; while (true) { let i = i + 1; if (i > 3) { break } }; i", 4},
+		{"let find = fn(xs, t) { for (x in xs) { if (x == t) { return true } } false }; find([1, 2], 2)", true},
+		{"let find = fn(xs, t) { for (x in xs) { if (x == t) { return true } } false }; find([1, 2], 3)", false},
+		{"let f = fn(n) { let s = 0; let i = 0; while (i < n) { let i = i + 1; if (i == 2) { continue } let s = s + i }; s }; f(4)", 8},
+		{"let f = fn() { for (x in [1]) { x } }; f()", NULL},
+		{"if (true) { while (false) { 1 } }", NULL},
+		{"while (true) { break }", NULL},
+		{"for (x in []) { x }", NULL},
+		{"let s = 0; for (i in [1, 2, 3]) { s = s + [1, if (i == 2) { continue } else { i }][1] }; s", 4},
+		{"let s = 0; for (i in [1, 2, 3]) { s = s + [1, if (i == 2) { break } else { i }][1] }; s", 1},
+		{"let s = 0; for (i in [1, 2]) { for (j in [1, 2]) { s = s + {\"a\": if (j == 2) { break } else { j }}[\"a\"] } }; s", 2},
+		{"let f = fn() { let i = 0; while (i < 3) { i += 1; let x = -(if (i > 1) { break } else { i }) }; i }; f()", 2},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunAssignments(t *testing.T) {
+	testCases := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; let y = 2; x = y = 3; x + y", 6},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{"let x = 1.5; x *= 2; x", "3.0"},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		{"let s = 0; for (i in range(5)) { s += i }; s", 10},
+		{"let f = fn(n) { let i = 0; while (i < n) { i += 1 }; i }; f(3)", 3},
+		{"let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n", 2},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c()", 2},
+		{"let a = [1, 2, 3]; a[1] = 5; a[1]", 5},
+		{"let a = [1, 2, 3]; a[2] += 10; a", "[1, 2, 13]"},
+		{"let a = [[1], [2]]; a[1][0] *= 3; a[1][0]", 6},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"a": "x"}; h["a"] += "y"`, "xy"},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunMatch(t *testing.T) {
+	testCases := []vmTestCase{
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (5) { 1 => "one", 2 => "two", _ => "many" }`, "many"},
+		{`match (-1) { -1 => "minus one", 1 => "one" }`, "minus one"},
+		{`match (1.0) { 1 => "int", 1.0 => "float" }`, "float"},
+		{`match ("b") { "a" => 1, "b" => 2, "b" => 3 }`, 2},
+		{`match (false) { true => 1, false => 0 }`, 0},
+		{"match ([1]) { 1 => 1 }", NULL},
+		{"match (3) {}", NULL},
+		{"match (3) { n => n * 2 }", 6},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b, _ => 0 }", 3},
+		{"match ([1, [2, 3]]) { [a, [_, c]] => a + c }", 4},
+		{"match ([1, 2, 3]) { [a, b] => 0, _ => -1 }", -1},
+		{`match ({"x": 1, "y": 2}) { {"x": x, "z": z} => 0, {"x": x, "y": 2} => x }`, 1},
+		{`match ({"type": "circle", "r": 2}) { {"type": "square", "a": a} => a * a, {"type": "circle", "r": r} => 3 * r * r }`, 12},
+		{`match ("x") { {} => 1, [] => 2, _ => 3 }`, 3},
+		{"match (7) { n if n < 5 => 1, n if n < 10 => 2, _ => 3 }", 2},
+		{"match ([3, 1]) { [a, b] if a < b => b, [a, b] => a }", 3},
+		{"let x = 1; match (2) { x => x }; x", 2},
+		{"let f = fn(n) { match (n) { 0 => 1, _ => n * f(n - 1) } }; f(5)", 120},
+		{"let f = fn(p) { match (p) { [a, b] => match (b) { [c] => a + c, _ => a } } }; f([1, [2]]) + f([1, 2])", 4},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunUnicodeStrings(t *testing.T) {
+	testCases := []vmTestCase{
+		{`"价格"[1]`, "格"},
+		{`"héllo"[1] + "héllo"[4]`, "éo"},
+		{"let 价格 = 10; let 数量 = 3; 价格 * 数量", 30},
+		{`let r = ""; for (c in "价格") { r = c + r }; r`, "格价"},
+	}
+	runVmTests(t, testCases)
+}
 func TestRunConditionals(t *testing.T) {
 	testCases := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -77,6 +334,9 @@ func TestRunConditionals(t *testing.T) {
 		{"if (1 < 2) { 10 } else { 20 }", 10},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 > 2) { 10 }", NULL},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", NULL},
 		{"if (false) { 10 }", NULL},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
 	}
@@ -87,6 +347,23 @@ func TestRunStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"a\tb\n"`, "a\tb\n"},
+		{"`C:\\dir\n${x}`", "C:\\dir\n${x}"},
+		{`let name = "monkey"; "Hello ${name}!"`, "Hello monkey!"},
+		{`let a = 1; let b = 2.5; "${a} + ${b} = ${a + b}"`, "1 + 2.5 = 3.5"},
+		{`"${[1, "x"]} ${true} ${if (false) { 1 }}"`, "[1, x] true null"},
+		{`"${"nested ${1 + 1}"}"`, "nested 2"},
+		{`let f = fn(n) { "<${n}>" }; f(1) + f("a")`, "<1><a>"},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunHashLiterals(t *testing.T) {
+	testCases := []vmTestCase{
+		{"{}", "{}"},
+		{"{1: 2, 2: 3}", "{1: 2, 2: 3}"},
+		{"{1 + 1: 2 * 2, 3 + 3: 4 * 4}", "{2: 4, 6: 16}"},
+		{`let k = "b"; {"a": 1, k: 2, "a": 3}`, "{a: 3, b: 2}"},
+		{`len({"a": 1, "b": 2})`, 2},
 	}
 	runVmTests(t, testCases)
 }
@@ -95,14 +372,45 @@ func TestRunIndexExpressions(t *testing.T) {
 		{"[1, 2, 3][1]", 2},
 		{"[1, 2, 3][0 + 2]", 3},
 		{"[[1, 1, 1]][0][0]", 1},
-		// {"[][0]", NULL},
-		// {"[1, 2, 3][99]", NULL},
-		// {"[1][-1]", NULL},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{`"价格"[-1]`, "格"},
+		{"let a = [1, 2, 3]; a[-1] += 1; a", "[1, 2, 4]"},
 
-		// {"{1: 1, 2: 2}[1]", 1},
-		// {"{1: 1, 2: 2}[2]", 2},
-		// {"{1: 1}[0]", NULL},
-		// {"{}[0]", NULL},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{1: 1}[0]", NULL},
+		{"{}[0]", NULL},
+		{`{"a": 1, true: 2}["a"]`, 1},
+		{`{"a": 1, true: 2}[true]`, 2},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunMemberExpressions(t *testing.T) {
+	testCases := []vmTestCase{
+		{`let order = {"customer": {"country": "NZ"}}; order.customer.country`, "NZ"},
+		{`let h = {"a": 1}; h.b`, NULL},
+		{`let m = {"double": fn(x) { x * 2 }}; m.double(21)`, 42},
+		{`let h = {}; h?.a?.b`, NULL},
+		{`let h = {}.h; h?.a.b`, NULL},
+		{`let h = {}; h.a?.b(1)[0][1:]`, NULL},
+		{`let h = {}; [h.a?.b.c, 1]`, "[null, 1]"},
+		{`let h = {"a": {}}; h.a.b = 1; h.a.b += 2; h.a`, "{b: 3}"},
+		{`let f = fn(h) { h.a ?? h.b ?? 0 }; [f({"a": 1}), f({"b": 2}), f({})]`, "[1, 2, 0]"},
+		{`let x = false; x ?? true`, false},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunSliceExpressions(t *testing.T) {
+	testCases := []vmTestCase{
+		{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+		{"[1, 2, 3, 4][:2]", "[1, 2]"},
+		{"[1, 2, 3, 4][-2:]", "[3, 4]"},
+		{"[1, 2, 3, 4][::-2]", "[4, 2]"},
+		{"let i = 1; let xs = [1, 2, 3, 4]; xs[i:i + 2]", "[2, 3]"},
+		{`"hello, 世界"[:5]`, "hello"},
+		{`"价格表"[::-1]`, "表格价"},
+		{"let f = fn(xs) { xs[1:] }; f(f([1, 2, 3]))", "[3]"},
 	}
 	runVmTests(t, testCases)
 }
@@ -122,16 +430,204 @@ func TestRunCallingFunctions(t *testing.T) {
 	}
 	runVmTests(t, testCases)
 }
+func TestRunClosures(t *testing.T) {
+	testCases := []vmTestCase{
+		{"let newClosure = fn(a) { fn() { a; }; }; let closure = newClosure(99); closure();", 99},
+		{"let newAdder = fn(a, b) { fn(c) { a + b + c }; }; let adder = newAdder(1, 2); adder(8);", 11},
+		{"let newAdder = fn(a, b) { let c = a + b; fn(d) { c + d }; }; let adder = newAdder(1, 2); adder(8);", 11},
+		{`
+		let newAdderOuter = fn(a, b) {
+			let c = a + b;
+			fn(d) {
+				let e = d + c;
+				fn(f) { e + f; };
+			};
+		};
+		let newAdderInner = newAdderOuter(1, 2)
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+		{`
+		let a = 1;
+		let newAdderOuter = fn(b) {
+			fn(c) {
+				fn(d) { a + b + c + d };
+			};
+		};
+		let newAdderInner = newAdderOuter(2)
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+		{`
+		let newClosure = fn(a, b) {
+			let one = fn() { a; };
+			let two = fn() { b; };
+			fn() { one() + two(); };
+		};
+		let closure = newClosure(9, 90);
+		closure();`, 99},
+		{"let f = fn() { let x = 0; let inc = fn() { x = x + 1; x }; inc(); inc(); x }; f()", 2},
+		{"let counter = fn() { let n = 0; [fn() { n += 1 }, fn() { n }] }; let c = counter(); c[0](); c[0](); c[1]()", 2},
+		// 捕获变量的cell不能被之后调用的函数复用
+		{"let f = fn() { let x = 1; fn() { x } }; let g = f(); let h = fn() { let y = 2; y }; h(); g()", 1},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunUnassignedVariables(t *testing.T) {
+	// 没有执行到let语句的变量为null
+	testCases := []vmTestCase{
+		{"if (false) { let x = 1 }; x", NULL},
+		{"if (false) { let x = 1 }; x ?? 2", 2},
+		{"fn() { if (false) { let x = 1 }; x }()", NULL},
+		{"let f = fn(c) { if (c) { let x = 1 }; x ?? 2 }; [f(true), f(false), f(true)]", "[1, 2, 1]"},
+		{"let f = fn() { if (false) { let x = 1 }; let g = fn() { x }; g() }; f()", NULL},
+	}
+	runVmTests(t, testCases)
+}
+func TestRunRecursiveFunctions(t *testing.T) {
+	testCases := []vmTestCase{
+		{`
+		let countDown = fn(x) {
+			if (x == 0) { return 0; } else { countDown(x - 1); }
+		};
+		countDown(1);`, 0},
+		{`
+		let countDown = fn(x) {
+			if (x == 0) { return 0; } else { countDown(x - 1); }
+		};
+		let wrapper = fn() { countDown(1); };
+		wrapper();`, 0},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) { return 0; } else { countDown(x - 1); }
+			};
+			countDown(1);
+		};
+		wrapper();`, 0},
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) { return 0; }
+			if (x == 1) { return 1; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+		{`
+		let wrapper = fn() {
+			let fib = fn(n) {
+				if (n < 2) { return n; }
+				fib(n - 1) + fib(n - 2);
+			};
+			fib(15);
+		};
+		wrapper();`, 610},
+	}
+	runVmTests(t, testCases)
+}
 func TestRunBuiltinFunctions(t *testing.T) {
 	testCases := []vmTestCase{
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("价格")`, 2},
+		{`len(bytes("价格"))`, 6},
+		{`bytes("é")`, "[195, 169]"},
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{"let k = 10; map([1, 2], fn(x) { x + k })", "[11, 12]"},
+		{"let f = fn(xs) { let n = 1; map(xs, fn(x) { x + n }) }; f([1, 2])", "[2, 3]"},
+		{"map([[1, 2], [3]], fn(xs) { reduce(xs, fn(a, b) { a + b }) })", "[3, 3]"},
+		{"let s = 0; for (x in filter(range(10), fn(x) { x % 3 == 0 })) { s += x }; s", 18},
+		{`sort_by(["ccc", "a", "bb"], fn(s) { len(s) })`, "[a, bb, ccc]"},
+		{`filter(["bob@a.com", "x@b.org"], fn(e) { matches(e, "@a\\.com$") })`, "[bob@a.com]"},
+		{`join(map(split("a b", " "), upper), "")`, "AB"},
+		{`format("%d-%s", 1, "a")`, "1-a"},
 	}
 	runVmTests(t, testCases)
 }
 
+func TestRunErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1;\nlet b = \"x\";\na + b", "rules.mk:3:3: type mismatch: INTEGER + STRING"},
+		{"let f = fn(x) {\n  x + \"a\"\n};\nf(1)", "rules.mk:2:5: type mismatch: INTEGER + STRING"},
+		{"let f = fn(x) { x };\n\nf(1, 2)", "rules.mk:3:2: wrong number of arguments: want=1, got=2"},
+		{"[1, 2][5]", "rules.mk:1:7: array index out of bounds: 5"},
+		{"let 价格 = \"价格\";\n价格[2]", "rules.mk:2:7: string index out of bounds: 2"},
+		{"1.5 - \"a\"", "rules.mk:1:5: type mismatch: FLOAT - STRING"},
+		{"let n = 0;\n100 % n", "rules.mk:2:5: modulo by zero"},
+		{"-\"a\"", "rules.mk:1:1: unknown operator: -STRING"},
+		{"let a = 1;\nfor (x in a) {}", "rules.mk:2:1: not iterable: INTEGER"},
+		{"let a = [1];\na[0] += \"x\"", "rules.mk:2:6: type mismatch: INTEGER + STRING"},
+		{"len(1)", "rules.mk:1:4: argument to `len` not supported, got INTEGER"},
+		{"let b = bytes(1);\nlen(b)", "rules.mk:1:14: argument to `bytes` must be STRING, got INTEGER"},
+		{"map([1], len)", "rules.mk:1:4: argument to `len` not supported, got INTEGER"},
+		{"let f = fn(x) {\n  1 / x\n};\nmap([1, 0], f)", "rules.mk:2:5: division by zero"},
+		{"map([[1], [0]], fn(xs) {\n  map(xs, fn(x) { 1 / x })\n})", "rules.mk:2:21: division by zero"},
+		{"map([1], fn(a, b) { a })", "rules.mk:1:4: wrong number of arguments: want=2, got=1"},
+		{"let h = {};\nh.a.b", "rules.mk:2:4: member access not supported: NULL"},
+		{"let a = [1, 2];\na[1:2:0]", "rules.mk:2:2: slice step cannot be zero"},
+		{"[1, 2][-3]", "rules.mk:1:7: array index out of bounds: -3"},
+		{"let f = fn(n) {\n  f(n + 1)\n};\nf(0)", "rules.mk:2:4: stack overflow"},
+		{"let f = fn(n) {\n  map([n], f)\n};\nf(0)", "rules.mk:2:6: stack overflow"},
+	}
+	for _, tt := range tests {
+		program := ast.NewParser(lexer.NewFileLexer("rules.mk", tt.input)).ParseProgram()
+		comp := NewCompiler(nil, []object.Object{}, nil)
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := NewVM(comp, make([]object.Object, GlobalSize)).Run()
+		if err == nil {
+			t.Fatalf("input: %s, expected runtime error", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+func TestRunCallDepth(t *testing.T) {
+	// 回调嵌套受MaxCallDepth限制, 回调内部的普通递归仍只受MaxFrames限制
+	input := `
+let depth = 0;
+let f = fn(n) { depth = n; map([n + 1], f) };
+let g = fn(n) { if (n == 0) { 0 } else { g(n - 1) } };
+map([1], fn(x) { g(4096) });
+f(0)`
+	program := ast.NewParser(lexer.NewLexer(input)).ParseProgram()
+	comp := NewCompiler(nil, []object.Object{}, nil)
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	globals := make([]object.Object, GlobalSize)
+	vm := NewVM(comp, globals)
+	err := vm.Run()
+	if err == nil || err.(*RuntimeError).Msg != "stack overflow" {
+		t.Fatalf("expected stack overflow, got=%v", err)
+	}
+	if depth := globals[0]; depth != object.Integer(MaxCallDepth) {
+		t.Errorf("wrong call depth. want=%d, got=%v", MaxCallDepth, depth)
+	}
+	if vm.callDepth != 0 {
+		t.Errorf("callDepth not restored, got=%d", vm.callDepth)
+	}
+}
+func TestCompileErrorPositions(t *testing.T) {
+	program := ast.NewParser(lexer.NewFileLexer("rules.mk", "let a = 1;\nfn() { a + b }")).ParseProgram()
+	err := NewCompiler(nil, []object.Object{}, nil).Compile(program)
+	if err == nil {
+		t.Fatal("expected compile error")
+	}
+	if expected := "rules.mk:2:12: undefined variable b"; err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, err.Error())
+	}
+
+	program = ast.NewParser(lexer.NewFileLexer("rules.mk", "len = 1")).ParseProgram()
+	err = NewCompiler(nil, []object.Object{}, nil).Compile(program)
+	if expected := "rules.mk:1:5: cannot assign to len"; err == nil || err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%v", expected, err)
+	}
+}
+
 func runVmTests(t *testing.T, testCases []vmTestCase) {
 	t.Helper()
 	for _, tt := range testCases {
@@ -143,17 +639,25 @@ func runVmTests(t *testing.T, testCases []vmTestCase) {
 		l := lexer.NewLexer(tt.input)
 		p := ast.NewParser(l)
 		program := p.ParseProgram()
-		comp := NewCompiler(nil, []object.Object{})
+		comp := NewCompiler(nil, []object.Object{}, nil)
 		if err := comp.Compile(program); err != nil {
 			t.Fatalf("compiler error: %s", err)
 		}
-		vm := NewVM(comp, make([]object.Object, GlobalSize))
+		globals := make([]object.Object, GlobalSize)
+		for i := range globals {
+			globals[i] = NULL
+		}
+		vm := NewVM(comp, globals)
 		if err := vm.Run(); err != nil {
 			t.Fatalf("vm error: %s", err)
 		}
 		result := vm.stack[vm.sp]
 		want := fmt.Sprint(tt.expected)
 		got := fmt.Sprint(result)
+		switch result.(type) {
+		case object.Float, *object.Array, *object.Hash:
+			got = result.Inspect()
+		}
 		if got != want {
 			t.Fatal("test fatal, input:'", tt.input, "', got", got, "want", want)
 		}